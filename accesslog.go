@@ -0,0 +1,412 @@
+package gearbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Access log formats
+const (
+	// AccessLogCommon is Common Log Format, e.g.
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /users HTTP/1.1" 200 2326
+	AccessLogCommon = "common"
+
+	// AccessLogCombined is Combined Log Format, it's Common Log Format
+	// followed by quoted referer and user agent
+	AccessLogCombined = "combined"
+
+	// AccessLogJSON writes each entry as a JSON object in a separate line
+	AccessLogJSON = "json"
+)
+
+const (
+	// defaultAccessLogBufferSize is the number of entries waiting to be written
+	defaultAccessLogBufferSize = 1024
+
+	// clfTimeFormat is the time format of Common Log Format
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogConfig holds settings of access logger
+type AccessLogConfig struct {
+	// Output is where entries are written to
+	Output io.Writer // default os.Stdout
+
+	// Format of entries, it's one of AccessLogCommon, AccessLogCombined
+	// and AccessLogJSON or a template with fields wrapped in braces, e.g.
+	// "{ip} {method} {path} {status} {latency}". Supported fields are time,
+	// method, path, uri, route, host, protocol, status, bytes, latency,
+	// latency_ms, ip, user_agent and referer
+	Format string // default AccessLogCommon
+
+	// Ratio of requests that are logged, it's between 0 and 1
+	SampleRate float64 // default 1
+
+	// Paths of requests that are not logged, paths ending with '*' exclude
+	// all paths starting with them, e.g. /static/*
+	ExcludePaths []string
+
+	// Maximum number of entries waiting to be written, entries are dropped
+	// instead of blocking requests if it's full
+	BufferSize int // default 1024
+}
+
+// accessLogEntry holds info of a logged request
+type accessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	URI       string        `json:"uri"`
+	Route     string        `json:"route"`
+	Host      string        `json:"host"`
+	Protocol  string        `json:"protocol"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Latency   time.Duration `json:"-"`
+	LatencyMs float64       `json:"latency_ms"`
+	IP        string        `json:"ip"`
+	UserAgent string        `json:"user_agent"`
+	Referer   string        `json:"referer"`
+}
+
+// accessLogFormatter appends formatted entry to buffer
+type accessLogFormatter func(b *bytes.Buffer, entry *accessLogEntry)
+
+// AccessLogger logs requests handled by its middleware, entries are
+// written by a background goroutine so requests are not blocked by output
+type AccessLogger struct {
+	format       accessLogFormatter
+	sampleRate   float64
+	excludePaths []string
+	writer       *bufio.Writer
+	entries      chan []byte
+	done         chan struct{}
+	mu           sync.RWMutex
+	closed       bool
+	dropped      uint64
+	err          error
+}
+
+// NewAccessLogger creates an access logger and starts writing its entries,
+// it returns an error if format is not valid
+func NewAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
+	}
+
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultAccessLogBufferSize
+	}
+
+	format, err := accessLogFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &AccessLogger{
+		format:       format,
+		sampleRate:   config.SampleRate,
+		excludePaths: config.ExcludePaths,
+		writer:       bufio.NewWriter(config.Output),
+		entries:      make(chan []byte, config.BufferSize),
+		done:         make(chan struct{}),
+	}
+
+	go l.run()
+
+	return l, nil
+}
+
+// Handler returns middleware that logs requests after running next handlers,
+// requests of panicking handlers are logged with HTTP status code 500 before
+// passing panic to be recovered
+func (l *AccessLogger) Handler() handlerFunc {
+	return func(ctx Context) {
+		if l.isExcluded(GetString(ctx.Context().Path())) {
+			ctx.Next()
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			if rcv := recover(); rcv != nil {
+				l.log(ctx, start, StatusInternalServerError)
+				panic(rcv)
+			}
+		}()
+
+		ctx.Next()
+		l.log(ctx, start, ctx.Context().Response.StatusCode())
+	}
+}
+
+// log formats entry of the request and queues it to be written if it's
+// sampled
+func (l *AccessLogger) log(ctx Context, start time.Time, status int) {
+	if l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	fctx := ctx.Context()
+	latency := time.Since(start)
+	entry := &accessLogEntry{
+		Time:      start,
+		Method:    string(fctx.Method()),
+		Path:      string(fctx.Path()),
+		URI:       string(fctx.RequestURI()),
+		Host:      string(fctx.Host()),
+		Protocol:  string(fctx.Request.Header.Protocol()),
+		Status:    status,
+		Latency:   latency,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		IP:        fctx.RemoteIP().String(),
+		UserAgent: string(fctx.UserAgent()),
+		Referer:   string(fctx.Referer()),
+	}
+
+	if route := ctx.(*context).route; route != nil {
+		entry.Route = route.Path
+	}
+
+	if fctx.Response.IsBodyStream() {
+		if length := fctx.Response.Header.ContentLength(); length > 0 {
+			entry.Bytes = length
+		}
+	} else {
+		entry.Bytes = len(fctx.Response.Body())
+	}
+
+	var b bytes.Buffer
+	l.format(&b, entry)
+	b.WriteByte('\n')
+	l.write(b.Bytes())
+}
+
+// Dropped returns number of entries dropped because buffer was full
+func (l *AccessLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close writes buffered entries and stops access logger, requests handled
+// after closing it are not logged. It returns the first error of writing
+// entries to output
+func (l *AccessLogger) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.mu.Unlock()
+
+	<-l.done
+	return l.err
+}
+
+// isExcluded checks if requests of path are not logged
+func (l *AccessLogger) isExcluded(path string) bool {
+	for _, excluded := range l.excludePaths {
+		if strings.HasSuffix(excluded, "*") {
+			if strings.HasPrefix(path, excluded[:len(excluded)-1]) {
+				return true
+			}
+		} else if path == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// write queues entry to be written without blocking, entry is dropped if
+// buffer is full or logger is closed
+func (l *AccessLogger) write(entry []byte) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	}
+
+	select {
+	case l.entries <- entry:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// run writes queued entries to output, output is flushed once there are
+// no more queued entries
+func (l *AccessLogger) run() {
+	defer close(l.done)
+
+	for entry := range l.entries {
+		if _, err := l.writer.Write(entry); err != nil && l.err == nil {
+			l.err = err
+		}
+
+		if len(l.entries) == 0 {
+			l.flush()
+		}
+	}
+	l.flush()
+}
+
+// flush writes buffered entries to output
+func (l *AccessLogger) flush() {
+	if err := l.writer.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// accessLogFormat returns formatter of one of predefined formats or
+// parses format as a template
+func accessLogFormat(format string) (accessLogFormatter, error) {
+	switch format {
+	case "", AccessLogCommon:
+		return formatCommonLog, nil
+	case AccessLogCombined:
+		return formatCombinedLog, nil
+	case AccessLogJSON:
+		return formatJSONLog, nil
+	}
+	return parseAccessLogTemplate(format)
+}
+
+// formatCommonLog formats entry using Common Log Format
+func formatCommonLog(b *bytes.Buffer, entry *accessLogEntry) {
+	b.WriteString(entry.IP)
+	b.WriteString(" - - [")
+	b.WriteString(entry.Time.Format(clfTimeFormat))
+	b.WriteString("] ")
+	b.WriteString(strconv.Quote(entry.Method + " " + entry.URI + " " + entry.Protocol))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(entry.Status))
+	b.WriteByte(' ')
+	if entry.Bytes == 0 {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(strconv.Itoa(entry.Bytes))
+	}
+}
+
+// formatCombinedLog formats entry using Combined Log Format
+func formatCombinedLog(b *bytes.Buffer, entry *accessLogEntry) {
+	formatCommonLog(b, entry)
+	b.WriteByte(' ')
+	b.WriteString(strconv.Quote(entry.Referer))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Quote(entry.UserAgent))
+}
+
+// formatJSONLog formats entry as a JSON object
+func formatJSONLog(b *bytes.Buffer, entry *accessLogEntry) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	raw, _ := json.Marshal(entry)
+	b.Write(raw)
+}
+
+// accessLogFields holds formatters of fields supported by templates
+var accessLogFields = map[string]accessLogFormatter{
+	"time": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Time.Format(time.RFC3339))
+	},
+	"method": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Method)
+	},
+	"path": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Path)
+	},
+	"uri": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.URI)
+	},
+	"route": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Route)
+	},
+	"host": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Host)
+	},
+	"protocol": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Protocol)
+	},
+	"status": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(strconv.Itoa(entry.Status))
+	},
+	"bytes": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(strconv.Itoa(entry.Bytes))
+	},
+	"latency": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Latency.String())
+	},
+	"latency_ms": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(strconv.FormatFloat(entry.LatencyMs, 'f', 3, 64))
+	},
+	"ip": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.IP)
+	},
+	"user_agent": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.UserAgent)
+	},
+	"referer": func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(entry.Referer)
+	},
+}
+
+// parseAccessLogTemplate parses template into text and fields formatters
+func parseAccessLogTemplate(template string) (accessLogFormatter, error) {
+	var parts []accessLogFormatter
+
+	for template != "" {
+		start := strings.IndexByte(template, '{')
+		if start == -1 {
+			parts = append(parts, accessLogText(template))
+			break
+		}
+
+		if start > 0 {
+			parts = append(parts, accessLogText(template[:start]))
+		}
+
+		end := strings.IndexByte(template[start:], '}')
+		if end == -1 {
+			return nil, errors.New("access log template has unclosed field at '" +
+				template[start:] + "'")
+		}
+
+		name := template[start+1 : start+end]
+		field, ok := accessLogFields[name]
+		if !ok {
+			return nil, errors.New("access log template has unknown field '" + name + "'")
+		}
+		parts = append(parts, field)
+
+		template = template[start+end+1:]
+	}
+
+	return func(b *bytes.Buffer, entry *accessLogEntry) {
+		for _, part := range parts {
+			part(b, entry)
+		}
+	}, nil
+}
+
+// accessLogText returns formatter that writes text as it is
+func accessLogText(text string) accessLogFormatter {
+	return func(b *bytes.Buffer, entry *accessLogEntry) {
+		b.WriteString(text)
+	}
+}
@@ -0,0 +1,278 @@
+package gearbox
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// errWriter fails writing
+type errWriter struct{}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// blockingWriter blocks writing until it's released
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// serveAccessLog handles requests using access logger middleware and returns
+// logged entries after closing it
+func serveAccessLog(t *testing.T, config AccessLogConfig, paths ...string) string {
+	var buf bytes.Buffer
+	config.Output = &buf
+
+	al, err := NewAccessLogger(config)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	gb := setupGearbox()
+	gb.Use(al.Handler())
+	gb.Get("/users/:id", func(ctx Context) {
+		ctx.SendString("user " + ctx.Param("id"))
+	})
+	gb.Get("/empty", emptyHandler)
+
+	startGearbox(gb)
+
+	for _, path := range paths {
+		req, _ := http.NewRequest(MethodGet, path, nil)
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("Referer", "http://example.com/")
+		if _, err := makeRequest(req, gb); err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+	}
+
+	if err := al.Close(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	return buf.String()
+}
+
+// TestAccessLogFormats tests formatting entries of access logger
+func TestAccessLogFormats(t *testing.T) {
+	testCases := []struct {
+		format   string
+		path     string
+		expected string
+	}{
+		{format: "", path: "/users/1?q=1",
+			expected: `^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/1\?q=1 HTTP/1\.1" 200 6\n$`},
+		{format: AccessLogCommon, path: "/empty",
+			expected: `^127\.0\.0\.1 - - \[.+\] "GET /empty HTTP/1\.1" 200 -\n$`},
+		{format: AccessLogCombined, path: "/users/1",
+			expected: `^127\.0\.0\.1 - - \[.+\] "GET /users/1 HTTP/1\.1" 200 6 "http://example\.com/" "test-agent"\n$`},
+		{format: "{method} {path} {uri} {route} {status} {bytes} {ip} {user_agent} {referer} {protocol} {host}", path: "/users/1?q=1",
+			expected: `^GET /users/1 /users/1\?q=1 /users/:id 200 6 127\.0\.0\.1 test-agent http://example\.com/ HTTP/1\.1 \n$`},
+		{format: "[{time}] {latency} {latency_ms}ms", path: "/missing",
+			expected: `^\[\d{4}-\d{2}-\d{2}T.+\] [0-9.]+[nµm]?s \d+\.\d{3}ms\n$`},
+		{format: "{route}|{status}", path: "/missing",
+			expected: "^\\|404\n$"},
+	}
+
+	for _, tc := range testCases {
+		logged := serveAccessLog(t, AccessLogConfig{Format: tc.format}, tc.path)
+		if !regexp.MustCompile(tc.expected).MatchString(logged) {
+			t.Errorf("format %q: logged %q expected %q", tc.format, logged, tc.expected)
+		}
+	}
+}
+
+// TestAccessLogJSON tests formatting entries as JSON lines
+func TestAccessLogJSON(t *testing.T) {
+	logged := serveAccessLog(t, AccessLogConfig{Format: AccessLogJSON}, "/users/1?q=1", "/empty")
+
+	lines := strings.Split(strings.TrimSuffix(logged, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines expected %d: %s", len(lines), 2, logged)
+	}
+
+	entry := make(map[string]interface{})
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("logged invalid JSON %s: %s", lines[0], err)
+	}
+
+	expected := map[string]interface{}{
+		"method":     "GET",
+		"path":       "/users/1",
+		"uri":        "/users/1?q=1",
+		"route":      "/users/:id",
+		"status":     float64(200),
+		"bytes":      float64(6),
+		"ip":         "127.0.0.1",
+		"user_agent": "test-agent",
+		"referer":    "http://example.com/",
+		"protocol":   "HTTP/1.1",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("logged %s=%v expected %v", key, entry[key], value)
+		}
+	}
+
+	for _, key := range []string{"time", "latency_ms"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("logged %s without %s", lines[0], key)
+		}
+	}
+}
+
+// TestAccessLogFiltering tests excluding paths and sampling requests
+func TestAccessLogFiltering(t *testing.T) {
+	testCases := []struct {
+		config AccessLogConfig
+		paths  []string
+		lines  int
+	}{
+		{config: AccessLogConfig{ExcludePaths: []string{"/empty", "/users/*"}},
+			paths: []string{"/empty", "/users/1", "/users/2", "/missing"}, lines: 1},
+		{config: AccessLogConfig{ExcludePaths: []string{"/users"}},
+			paths: []string{"/users/1", "/empty"}, lines: 2},
+		{config: AccessLogConfig{SampleRate: 1e-12},
+			paths: []string{"/users/1", "/empty", "/missing"}, lines: 0},
+		{config: AccessLogConfig{SampleRate: 2},
+			paths: []string{"/users/1", "/empty", "/missing"}, lines: 3},
+	}
+
+	for _, tc := range testCases {
+		logged := serveAccessLog(t, tc.config, tc.paths...)
+		if lines := strings.Count(logged, "\n"); lines != tc.lines {
+			t.Errorf("logged %d lines expected %d: %s", lines, tc.lines, logged)
+		}
+	}
+}
+
+// TestAccessLogTemplateErrors tests creating access logger with invalid
+// templates
+func TestAccessLogTemplateErrors(t *testing.T) {
+	testCases := []struct {
+		format string
+		err    string
+	}{
+		{format: "{method} {unknown}", err: "access log template has unknown field 'unknown'"},
+		{format: "{method} {status", err: "access log template has unclosed field at '{status'"},
+	}
+
+	for _, tc := range testCases {
+		al, err := NewAccessLogger(AccessLogConfig{Format: tc.format})
+		if al != nil || err == nil || err.Error() != tc.err {
+			t.Errorf("format %q: returned error %v expected %s", tc.format, err, tc.err)
+		}
+	}
+}
+
+// TestAccessLogNonBlocking tests dropping entries instead of blocking
+// requests when output is slow and after closing access logger
+func TestAccessLogNonBlocking(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	al, _ := NewAccessLogger(AccessLogConfig{Output: writer, BufferSize: 1})
+
+	gb := setupGearbox()
+	gb.Use(al.Handler())
+	gb.Get("/", emptyHandler)
+	startGearbox(gb)
+
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(MethodGet, "/", nil)
+		if _, err := makeRequest(req, gb); err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+	}
+
+	if dropped := al.Dropped(); dropped < 8 {
+		t.Errorf("dropped %d entries expected at least %d", dropped, 8)
+	}
+
+	close(writer.release)
+	if err := al.Close(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	dropped := al.Dropped()
+	req, _ := http.NewRequest(MethodGet, "/", nil)
+	if _, err := makeRequest(req, gb); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if al.Dropped() != dropped+1 {
+		t.Errorf("dropped %d entries expected %d", al.Dropped(), dropped+1)
+	}
+
+	if err := al.Close(); err != nil {
+		t.Errorf("closing again returned %s", err.Error())
+	}
+}
+
+// TestAccessLogWriteError tests reporting errors of writing entries
+func TestAccessLogWriteError(t *testing.T) {
+	al, _ := NewAccessLogger(AccessLogConfig{Output: &errWriter{}})
+
+	gb := setupGearbox()
+	gb.Use(al.Handler())
+	gb.Get("/", emptyHandler)
+	startGearbox(gb)
+
+	req, _ := http.NewRequest(MethodGet, "/", nil)
+	if _, err := makeRequest(req, gb); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if err := al.Close(); err == nil || err.Error() != "write failed" {
+		t.Errorf("returned error %v expected %s", err, "write failed")
+	}
+}
+
+// TestAccessLogPanic tests logging requests of panicking handlers
+func TestAccessLogPanic(t *testing.T) {
+	var buf bytes.Buffer
+	al, _ := NewAccessLogger(AccessLogConfig{Output: &buf, Format: "{path} {status}"})
+
+	gb := setupGearbox(&Settings{AutoRecover: true})
+	gb.Use(al.Handler())
+	gb.Get("/ok", emptyHandler)
+	gb.Get("/boom", func(ctx Context) {
+		panic("boom")
+	})
+	startGearbox(gb)
+
+	for _, tc := range []struct {
+		path       string
+		statusCode int
+	}{
+		{path: "/boom", statusCode: StatusInternalServerError},
+		{path: "/ok", statusCode: StatusOK},
+	} {
+		req, _ := http.NewRequest(MethodGet, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s: returned %d expected %d", tc.path, response.StatusCode, tc.statusCode)
+		}
+	}
+
+	if err := al.Close(); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if expected := "/boom 500\n/ok 200\n"; buf.String() != expected {
+		t.Errorf("logged %q expected %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,151 @@
+package gearbox
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCacheShards is the maximum number of shards used by routing cache,
+// each shard has its own lock to reduce contention between requests
+const maxCacheShards = 16
+
+// CacheStats holds counters of routing cache
+type CacheStats struct {
+	Hits    uint64 // number of requests matched from cache
+	Misses  uint64 // number of requests matched by walking routing tree
+	Entries int    // number of entries currently stored in cache
+}
+
+// routeCache is a sharded least recently used cache for matched routes
+type routeCache struct {
+	// counters are kept first to be 64-bit aligned for atomic operations
+	hits   uint64
+	misses uint64
+	shards []*cacheShard
+}
+
+// cacheShard holds a part of cache entries ordered by their last usage
+type cacheShard struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+// cacheEntry is an element stored in shard's usage list
+type cacheEntry struct {
+	key    string
+	result *matchResult
+}
+
+// newRouteCache creates a routing cache that holds up to size entries
+func newRouteCache(size int) *routeCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+
+	shardsCount := maxCacheShards
+	if size < shardsCount {
+		shardsCount = size
+	}
+
+	c := &routeCache{
+		shards: make([]*cacheShard, shardsCount),
+	}
+
+	for i := range c.shards {
+		// Distribute remaining entries over first shards to keep total size
+		capacity := size / shardsCount
+		if i < size%shardsCount {
+			capacity++
+		}
+
+		c.shards[i] = &cacheShard{
+			capacity: capacity,
+			items:    make(map[string]*list.Element, capacity),
+			order:    list.New(),
+		}
+	}
+
+	return c
+}
+
+// shard returns the shard responsible for the key
+func (c *routeCache) shard(key string) *cacheShard {
+	// FNV-1a hash
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return c.shards[hash%uint32(len(c.shards))]
+}
+
+// get returns cached result of the key and marks it as recently used
+func (c *routeCache) get(key string) *matchResult {
+	s := c.shard(key)
+
+	s.mutex.Lock()
+	element, ok := s.items[key]
+	if !ok {
+		s.mutex.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return nil
+	}
+	s.order.MoveToFront(element)
+	result := element.Value.(*cacheEntry).result
+	s.mutex.Unlock()
+
+	atomic.AddUint64(&c.hits, 1)
+	return result
+}
+
+// set stores result of the key and evicts the least recently used entry
+// if shard is full
+func (c *routeCache) set(key string, result *matchResult) {
+	s := c.shard(key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		element.Value.(*cacheEntry).result = result
+		s.order.MoveToFront(element)
+		return
+	}
+
+	if s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*cacheEntry).key)
+	}
+
+	s.items[key] = s.order.PushFront(&cacheEntry{key: key, result: result})
+}
+
+// clear removes all entries of cache, counters are kept
+func (c *routeCache) clear() {
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		s.items = make(map[string]*list.Element, s.capacity)
+		s.order.Init()
+		s.mutex.Unlock()
+	}
+}
+
+// stats returns counters of cache
+func (c *routeCache) stats() CacheStats {
+	stats := CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		stats.Entries += s.order.Len()
+		s.mutex.Unlock()
+	}
+
+	return stats
+}
@@ -0,0 +1,131 @@
+package gearbox
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestCacheEviction tests that least recently used entries are evicted first
+func TestCacheEviction(t *testing.T) {
+	// Each shard can hold two entries
+	cache := newRouteCache(2 * maxCacheShards)
+
+	// Find keys stored in the same shard
+	keys := make([]string, 0, 3)
+	for i := 0; len(keys) < 3; i++ {
+		key := "GET/" + strconv.Itoa(i)
+		if cache.shard(key) == cache.shards[0] {
+			keys = append(keys, key)
+		}
+	}
+
+	cache.set(keys[0], &matchResult{})
+	cache.set(keys[1], &matchResult{})
+
+	// Use first key to make the second one the least recently used
+	if cache.get(keys[0]) == nil {
+		t.Fatalf("expected '%s' to be cached", keys[0])
+	}
+
+	cache.set(keys[2], &matchResult{})
+
+	testCases := []struct {
+		key    string
+		cached bool
+	}{
+		{key: keys[0], cached: true},
+		{key: keys[1], cached: false},
+		{key: keys[2], cached: true},
+	}
+
+	for _, tc := range testCases {
+		if cached := cache.get(tc.key) != nil; cached != tc.cached {
+			t.Errorf("key '%s': cached %t expected %t", tc.key, cached, tc.cached)
+		}
+	}
+}
+
+// TestCacheSize tests that cache never holds more entries than its size
+func TestCacheSize(t *testing.T) {
+	sizes := []int{1, 10, 17, 1000}
+
+	for _, size := range sizes {
+		cache := newRouteCache(size)
+
+		for i := 0; i < size*3; i++ {
+			cache.set("GET/"+strconv.Itoa(i), &matchResult{})
+		}
+
+		if entries := cache.stats().Entries; entries > size {
+			t.Errorf("cache of size %d holds %d entries", size, entries)
+		}
+	}
+
+	if cache := newRouteCache(0); len(cache.shards) != maxCacheShards {
+		t.Errorf("cache with invalid size has %d shards expected %d", len(cache.shards), maxCacheShards)
+	}
+}
+
+// TestCacheStats tests hits and misses counters
+func TestCacheStats(t *testing.T) {
+	cache := newRouteCache(10)
+
+	cache.get("GET/a")
+	cache.set("GET/a", &matchResult{})
+	cache.set("GET/a", &matchResult{})
+	cache.get("GET/a")
+	cache.get("GET/a")
+
+	stats := cache.stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected cache stats %+v", stats)
+	}
+}
+
+// TestCacheClear tests removing entries of cache
+func TestCacheClear(t *testing.T) {
+	cache := newRouteCache(10)
+
+	cache.set("GET/a", &matchResult{})
+	cache.set("GET/b", &matchResult{})
+	cache.clear()
+
+	if result := cache.get("GET/a"); result != nil {
+		t.Errorf("returned result of cleared entry")
+	}
+
+	if entries := cache.stats().Entries; entries != 0 {
+		t.Errorf("cleared cache holds %d entries", entries)
+	}
+
+	cache.set("GET/a", &matchResult{})
+	if result := cache.get("GET/a"); result == nil {
+		t.Errorf("no result of entry set after clearing cache")
+	}
+}
+
+// TestCacheConcurrency tests using cache from multiple goroutines
+func TestCacheConcurrency(t *testing.T) {
+	cache := newRouteCache(100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := "GET/" + strconv.Itoa((i*j)%300)
+				if cache.get(key) == nil {
+					cache.set(key, &matchResult{})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := cache.stats()
+	if stats.Hits+stats.Misses != 8000 {
+		t.Errorf("unexpected cache stats %+v", stats)
+	}
+}
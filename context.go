@@ -2,6 +2,7 @@ package gearbox
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 
 	jsoniter "github.com/json-iterator/go"
@@ -10,7 +11,8 @@ import (
 
 // MIME types
 const (
-	MIMEApplicationJSON = "application/json"
+	MIMEApplicationJSON        = "application/json"
+	MIMEApplicationProblemJSON = "application/problem+json"
 )
 
 // Context interface
@@ -18,10 +20,14 @@ type Context interface {
 	Next()
 	Context() *fasthttp.RequestCtx
 	Param(key string) string
+	RawParam(key string) string
+	RouteName() string
+	RouteMeta(key string) interface{}
 	Query(key string) string
 	SendBytes(value []byte) Context
 	SendString(value string) Context
 	SendJSON(in interface{}) error
+	SendProblem(problem Problem) error
 	Status(status int) Context
 	Set(key string, value string)
 	Get(key string) string
@@ -37,12 +43,25 @@ type handlerFunc func(ctx Context)
 // handlersChain defines a handlerFunc array.
 type handlersChain []handlerFunc
 
+// pathParam holds key and value of a path parameter, value is unescaped if
+// unescaping path values is enabled otherwise it's the same as raw value
+type pathParam struct {
+	key   string
+	value string
+	raw   string
+}
+
 // Context defines the current context of request and handlers/middlewares to execute
 type context struct {
-	requestCtx  *fasthttp.RequestCtx
-	paramValues map[string]string
-	handlers    handlersChain
-	index       int
+	requestCtx *fasthttp.RequestCtx
+	params     []pathParam
+	handlers   handlersChain
+	route      *Route
+	settings   *Settings
+	index      int
+	cacheKey   []byte
+	hosts      []*hostTrees // host patterns that match request's host
+	matches    uint32       // number of routes matched using context
 }
 
 // Next function is used to successfully pass from current middleware to next middleware.
@@ -54,9 +73,102 @@ func (ctx *context) Next() {
 	}
 }
 
+// run executes handlers starting with the first one, the handler is executed
+// instead if there are no handlers
+func (ctx *context) run(handlers handlersChain, handler handlerFunc) {
+	if len(handlers) == 0 {
+		handler(ctx)
+		return
+	}
+
+	ctx.handlers = handlers
+	ctx.index = 0
+	ctx.handlers[0](ctx)
+}
+
+// SendProblem answers with problem details document, its missing type,
+// title, status and instance are filled with about:blank, status text,
+// 500 and request path
+func (ctx *context) SendProblem(problem Problem) error {
+	return writeProblem(ctx.requestCtx, problem)
+}
+
+// sendError answers with status code and message as plain text, or as
+// problem document if it's enabled
+func (ctx *context) sendError(code int, message string) {
+	writeError(ctx.requestCtx, ctx.settings, code, message)
+}
+
+// handleError answers request using error handler of settings
+func (ctx *context) handleError(err error) {
+	if ctx.settings == nil || ctx.settings.ErrorHandler == nil {
+		DefaultErrorHandler(ctx, err)
+		return
+	}
+	ctx.settings.ErrorHandler(ctx, err)
+}
+
 // Param returns value of path parameter specified by key
 func (ctx *context) Param(key string) string {
-	return ctx.paramValues[key]
+	for i := range ctx.params {
+		if ctx.params[i].key == key {
+			return ctx.params[i].value
+		}
+	}
+	return ""
+}
+
+// RawParam returns value of path parameter specified by key as it's in
+// request's path without unescaping it
+func (ctx *context) RawParam(key string) string {
+	for i := range ctx.params {
+		if ctx.params[i].key == key {
+			return ctx.params[i].raw
+		}
+	}
+	return ""
+}
+
+// RouteName returns name of the matched route
+func (ctx *context) RouteName() string {
+	if ctx.route == nil {
+		return ""
+	}
+	return ctx.route.name
+}
+
+// RouteMeta returns value attached to the matched route with the key
+func (ctx *context) RouteMeta(key string) interface{} {
+	if ctx.route == nil {
+		return nil
+	}
+	return ctx.route.meta[key]
+}
+
+// setParam adds value of path parameter specified by key
+func (ctx *context) setParam(key, value string) {
+	ctx.params = append(ctx.params, pathParam{key: key, value: value, raw: value})
+}
+
+// countHit counts a route match and reports whether hits of tree nodes
+// should be counted for it, only one of hitsSampleRate matches is counted
+func (ctx *context) countHit() bool {
+	ctx.matches++
+	return ctx.matches%hitsSampleRate == 0
+}
+
+// unescapeParams decodes percent-encoded values of path parameters,
+// values that can not be decoded are kept as they are
+func (ctx *context) unescapeParams() {
+	for i := range ctx.params {
+		if strings.IndexByte(ctx.params[i].raw, '%') == -1 {
+			continue
+		}
+
+		if value, err := url.PathUnescape(ctx.params[i].raw); err == nil {
+			ctx.params[i].value = value
+		}
+	}
 }
 
 // Context returns Fasthttp context
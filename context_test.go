@@ -52,3 +52,36 @@ func TestNext(t *testing.T) {
 		}
 	}
 }
+
+// TestUnescapeParams tests decoding percent-encoded params
+func TestUnescapeParams(t *testing.T) {
+	ctx := &context{}
+	ctx.setParam("name", "john%20doe")
+	ctx.setParam("path", "a%2Fb")
+	ctx.setParam("invalid", "100%zz")
+	ctx.setParam("plain", "test+value")
+
+	ctx.unescapeParams()
+
+	testCases := []struct {
+		key   string
+		value string
+		raw   string
+	}{
+		{key: "name", value: "john doe", raw: "john%20doe"},
+		{key: "path", value: "a/b", raw: "a%2Fb"},
+		{key: "invalid", value: "100%zz", raw: "100%zz"},
+		{key: "plain", value: "test+value", raw: "test+value"},
+		{key: "missing", value: "", raw: ""},
+	}
+
+	for _, tc := range testCases {
+		if value := ctx.Param(tc.key); value != tc.value {
+			t.Errorf("param '%s': returned '%s' expected '%s'", tc.key, value, tc.value)
+		}
+
+		if raw := ctx.RawParam(tc.key); raw != tc.raw {
+			t.Errorf("raw param '%s': returned '%s' expected '%s'", tc.key, raw, tc.raw)
+		}
+	}
+}
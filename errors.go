@@ -0,0 +1,77 @@
+package gearbox
+
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HTTPError is an error with HTTP status code and message to answer the
+// request with, its cause is kept for logging and it's not sent to clients
+type HTTPError struct {
+	Code    int    // HTTP status code
+	Message string // message sent to client
+	Err     error  // internal cause
+}
+
+// NewHTTPError returns an HTTPError with status code, message and cause,
+// status text of code is used as message if it's empty
+func NewHTTPError(code int, message string, err error) *HTTPError {
+	if message == "" {
+		message = fasthttp.StatusMessage(code)
+	}
+
+	return &HTTPError{
+		Code:    code,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// Error returns status code and message of error with its cause
+func (e *HTTPError) Error() string {
+	message := fasthttp.StatusMessage(e.Code)
+	if e.Message != "" {
+		message = e.Message
+	}
+
+	if e.Err != nil {
+		return message + ": " + e.Err.Error()
+	}
+	return message
+}
+
+// Unwrap returns cause of error
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// Handle converts a handler that returns an error to a handler that can be
+// registered with routes, returned errors are handled by ErrorHandler
+func Handle(handler func(ctx Context) error) handlerFunc {
+	return func(ctx Context) {
+		if err := handler(ctx); err != nil {
+			ctx.(*context).handleError(err)
+		}
+	}
+}
+
+// DefaultErrorHandler answers with status code and message of HTTPError,
+// other errors are answered with HTTP status code 500 without exposing them.
+// Responses are problem documents if ProblemJSON is enabled
+func DefaultErrorHandler(ctx Context, err error) {
+	code := StatusInternalServerError
+	message := fasthttp.StatusMessage(code)
+
+	var httpError *HTTPError
+	if errors.As(err, &httpError) {
+		code = httpError.Code
+		message = httpError.Message
+	}
+
+	var settings *Settings
+	if c, ok := ctx.(*context); ok {
+		settings = c.settings
+	}
+	writeError(ctx.Context(), settings, code, message)
+}
@@ -0,0 +1,107 @@
+package gearbox
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// TestHTTPError tests messages and causes of HTTP errors
+func TestHTTPError(t *testing.T) {
+	cause := errors.New("record not found")
+
+	testCases := []struct {
+		err     *HTTPError
+		message string
+		text    string
+	}{
+		{err: NewHTTPError(StatusNotFound, "", nil), message: "Not Found", text: "Not Found"},
+		{err: NewHTTPError(StatusNotFound, "user not found", cause), message: "user not found",
+			text: "user not found: record not found"},
+		{err: &HTTPError{Code: StatusBadRequest}, message: "", text: "Bad Request"},
+	}
+
+	for _, tc := range testCases {
+		if tc.err.Message != tc.message {
+			t.Errorf("message is %s expected %s", tc.err.Message, tc.message)
+		}
+
+		if tc.err.Error() != tc.text {
+			t.Errorf("error is %s expected %s", tc.err.Error(), tc.text)
+		}
+	}
+
+	if !errors.Is(NewHTTPError(StatusNotFound, "", cause), cause) {
+		t.Errorf("cause is not unwrapped")
+	}
+}
+
+// TestHandleErrors tests handling errors returned by handlers
+func TestHandleErrors(t *testing.T) {
+	testCases := []struct {
+		errorHandler func(ctx Context, err error)
+		path         string
+		statusCode   int
+		body         string
+	}{
+		{path: "/ok", statusCode: StatusOK, body: "ok"},
+		{path: "/http", statusCode: StatusNotFound, body: "user not found"},
+		{path: "/status", statusCode: StatusForbidden, body: "Forbidden"},
+		{path: "/wrapped", statusCode: StatusConflict, body: "conflict"},
+		{path: "/internal", statusCode: StatusInternalServerError, body: "Internal Server Error"},
+		{path: "/middleware", statusCode: StatusUnauthorized, body: "Unauthorized"},
+		{
+			errorHandler: func(ctx Context, err error) {
+				ctx.Status(StatusTeapot).SendString("custom: " + err.Error())
+			},
+			path:       "/internal",
+			statusCode: StatusTeapot,
+			body:       "custom: database is down",
+		},
+	}
+
+	for _, tc := range testCases {
+		gb := setupGearbox(&Settings{
+			ErrorHandler: tc.errorHandler,
+		})
+
+		gb.Get("/ok", Handle(func(ctx Context) error {
+			ctx.SendString("ok")
+			return nil
+		}))
+		gb.Get("/http", Handle(func(ctx Context) error {
+			return NewHTTPError(StatusNotFound, "user not found", errors.New("no rows"))
+		}))
+		gb.Get("/status", Handle(func(ctx Context) error {
+			return &HTTPError{Code: StatusForbidden}
+		}))
+		gb.Get("/wrapped", Handle(func(ctx Context) error {
+			return fmt.Errorf("saving user: %w", NewHTTPError(StatusConflict, "conflict", nil))
+		}))
+		gb.Get("/internal", Handle(func(ctx Context) error {
+			return errors.New("database is down")
+		}))
+		gb.Get("/middleware", Handle(func(ctx Context) error {
+			return NewHTTPError(StatusUnauthorized, "", nil)
+		}), emptyHandler)
+
+		startGearbox(gb)
+
+		req, _ := http.NewRequest(MethodGet, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", MethodGet, tc.path, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s(%s): returned %d expected %d", MethodGet, tc.path, response.StatusCode, tc.statusCode)
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != tc.body {
+			t.Errorf("%s(%s): returned %s expected %s", MethodGet, tc.path, body, tc.body)
+		}
+	}
+}
@@ -2,11 +2,12 @@
 package gearbox
 
 import (
+	"errors"
 	"log"
 	"net"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -24,8 +25,7 @@ const (
 | |  __   ___   __ _  _ __ | |__    ___ __  __
 | | |_ | / _ \ / _' || '__|| '_ \  / _ \\ \/ /
 | |__| ||  __/| (_| || |   | |_) || (_) |>  < 
- \_____| \___| \__,_||_|   |_.__/  \___//_/\_\ v%s
-Listening on %s`
+ \_____| \___| \__,_||_|   |_.__/  \___//_/\_\ v%s`
 )
 
 const (
@@ -43,6 +43,10 @@ const (
 
 	// defaultMaxRequestURLLength is the maximum request url length
 	defaultMaxRequestURLLength = 2048
+
+	// staticContextKey is the key of user value holding context of
+	// requests handled by static files handler
+	staticContextKey = "gearbox.staticContext"
 )
 
 // HTTP methods were copied from net/http.
@@ -58,6 +62,12 @@ const (
 	MethodTrace   = "TRACE"   // RFC 7231, 4.3.8
 )
 
+// standardMethods holds all http methods above that are registered by Any
+var standardMethods = []string{
+	MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch,
+	MethodDelete, MethodConnect, MethodOptions, MethodTrace,
+}
+
 // HTTP status codes were copied from net/http.
 const (
 	StatusContinue           = 100 // RFC 7231, 6.2.1
@@ -128,6 +138,7 @@ const (
 
 // Gearbox interface
 type Gearbox interface {
+	Build() error
 	Start(address string) error
 	Stop() error
 	Get(path string, handlers ...handlerFunc) *Route
@@ -139,10 +150,17 @@ type Gearbox interface {
 	Connect(path string, handlers ...handlerFunc) *Route
 	Options(path string, handlers ...handlerFunc) *Route
 	Trace(path string, handlers ...handlerFunc) *Route
-	Group(prefix string, routes []*Route) []*Route
+	Any(path string, handlers ...handlerFunc) []*Route
+	Match(methods []string, path string, handlers ...handlerFunc) []*Route
+	Group(prefix string) Group
+	Host(pattern string, routes []*Route) []*Route
 	Static(prefix, root string)
 	NotFound(handlers ...handlerFunc)
 	Use(middlewares ...handlerFunc)
+	CacheStats() CacheStats
+	URL(name string, pairs ...string) (string, error)
+	Routes() []RouteInfo
+	RoutesHandler() handlerFunc
 }
 
 // gearbox implements Gearbox interface
@@ -150,6 +168,9 @@ type gearbox struct {
 	httpServer       *fasthttp.Server
 	router           *router
 	registeredRoutes []*Route
+	routerRoutes     int // number of registered routes added to router
+	routeErrors      RouteErrors
+	started          uint32 // set once service is started
 	address          string // server address
 	middlewares      handlersChain
 	settings         *Settings
@@ -157,12 +178,19 @@ type gearbox struct {
 
 // Settings struct holds server settings
 type Settings struct {
-	// Enable case sensitive routing
+	// Enable case insensitive routing, it applies to static parts of routes
+	// while params keep the original case of request's path
 	CaseInSensitive bool // default false
 
+	// Enables decoding percent-encoded values of path params, e.g. %20,
+	// routes are matched against the original path so an encoded slash
+	// (%2F) does not split segments and it's decoded inside param's value.
+	// Original values are still accessible through Context.RawParam
+	UnescapePathValues bool // default false
+
 	// Maximum size of LRU cache that will be used in routing if it's enabled
 	CacheSize int // default 1000
-	
+
 	// Per-connection buffer size for requests' reading.
 	// This also limits the maximum header size.
 	//
@@ -172,6 +200,21 @@ type Settings struct {
 	// Default buffer size is used if not set.
 	ReadBufferSize int
 
+	// Enables redirecting requests to the path of matched route if they
+	// differ in trailing slash only, e.g. /users/ to /users. Requests are
+	// redirected with HTTP status code 301 for GET and 308 for other methods
+	RedirectTrailingSlash bool // default false
+
+	// Enables redirecting requests that do not match any route to the path
+	// after cleaning it from duplicate slashes, . and .. elements, e.g.
+	// /a//b/../c to /a/c, and correcting its case if routing is case sensitive
+	RedirectFixedPath bool // default false
+
+	// Disables handling HEAD requests by GET routes if there are no HEAD
+	// routes registered for the same paths, responses of HEAD requests
+	// keep headers of GET responses including Content-Length without body
+	DisableAutoHead bool // default false
+
 	// Enables answering with HTTP status code 405 if request does not match
 	// with any route, but there are another methods are allowed for that route
 	// otherwise answer with Not Found handlers or status code 404.
@@ -181,21 +224,39 @@ type Settings struct {
 	// registered for that route
 	HandleOPTIONS bool // default false
 
+	// Handles errors returned by handlers registered using Handle by
+	// answering requests, HTTPError is answered with its status code and
+	// message and other errors with HTTP status code 500
+	ErrorHandler func(ctx Context, err error) // default DefaultErrorHandler
+
+	// Enables rendering responses of framework-generated errors and errors
+	// handled by DefaultErrorHandler as RFC 7807 problem details documents
+	// with application/problem+json content type
+	ProblemJSON bool // default false
+
 	// Enables automatic recovering from panic while executing handlers by
-	// answering with HTTP status code 500 and logging error message without
-	// stopping service
+	// passing it to RecoverHandler without stopping service, by default it
+	// answers with HTTP status code 500 and logs error message
 	AutoRecover bool // default false
 
+	// Handles panics recovered if AutoRecover is enabled, it's called with
+	// request's context, recovered value and stack trace after dropping
+	// response written by handlers. DevelopmentRecoverHandler can be used
+	// to render them as HTML page while developing
+	RecoverHandler func(ctx Context, recovered interface{}, stack []byte) // default DefaultRecoverHandler
+
 	// ServerName for sending in response headers
 	ServerName string // default ""
 
 	// Maximum request body size
 	MaxRequestBodySize int // default 4 * 1024 * 1024
 
-	// Maximum number of route params count
+	// Maximum number of route params count, registering a route with more
+	// params than this limit fails
 	MaxRouteParams int // default 1024
 
-	// Max request url length
+	// Max request url length, longer requests are answered with
+	// HTTP status code 414
 	MaxRequestURLLength int // default 2048
 
 	// Maximum number of concurrent connections
@@ -208,9 +269,17 @@ type Settings struct {
 	// LRU caching used to speed up routing
 	DisableCaching bool // default false
 
-	// Disable printing gearbox banner
+	// Disable printing gearbox banner and logging startup message
 	DisableStartupMessage bool // default false
 
+	// Logs messages of gearbox, e.g. startup message and recovered panics,
+	// NewStdLogger and NewJSONLogger can be used to create one
+	Logger Logger // default NewStdLogger(nil, LogLevelInfo)
+
+	// Enables logging errors of fasthttp server, e.g. failures of reading
+	// requests, through Logger with error level
+	LogServerErrors bool // default false
+
 	// Disable keep-alive connections, the server will close incoming connections after sending the first response to client
 	DisableKeepalive bool // default false
 
@@ -244,9 +313,15 @@ type Settings struct {
 
 // Route struct which holds each route info
 type Route struct {
-	Method   string
-	Path     string
-	Handlers handlersChain
+	Method      string
+	Path        string
+	Host        string // host pattern, empty for routes of any host
+	Handlers    handlersChain
+	group       *group
+	middlewares handlersChain
+	chain       handlersChain // middlewares and handlers added to router
+	name        string
+	meta        map[string]interface{}
 }
 
 // New creates a new instance of gearbox
@@ -273,7 +348,7 @@ func New(settings ...*Settings) Gearbox {
 		gb.settings.MaxRouteParams = defaultMaxRouteParams
 	}
 
-	if gb.settings.MaxRequestURLLength <= 0 || gb.settings.MaxRequestURLLength > defaultMaxRequestURLLength {
+	if gb.settings.MaxRequestURLLength <= 0 {
 		gb.settings.MaxRequestURLLength = defaultMaxRequestURLLength
 	}
 
@@ -281,10 +356,21 @@ func New(settings ...*Settings) Gearbox {
 		gb.settings.Concurrency = defaultConcurrency
 	}
 
+	if gb.settings.ErrorHandler == nil {
+		gb.settings.ErrorHandler = DefaultErrorHandler
+	}
+
+	if gb.settings.RecoverHandler == nil {
+		gb.settings.RecoverHandler = DefaultRecoverHandler
+	}
+
+	if gb.settings.Logger == nil {
+		gb.settings.Logger = defaultLogger
+	}
+
 	// Initialize router
 	gb.router = &router{
 		settings: gb.settings,
-		cache:    make(map[string]*matchResult),
 		pool: sync.Pool{
 			New: func() interface{} {
 				return new(context)
@@ -292,6 +378,10 @@ func New(settings ...*Settings) Gearbox {
 		},
 	}
 
+	if !gb.settings.DisableCaching {
+		gb.router.cache = newRouteCache(gb.settings.CacheSize)
+	}
+
 	gb.httpServer = gb.newHTTPServer()
 
 	return gb
@@ -300,11 +390,16 @@ func New(settings ...*Settings) Gearbox {
 // Start handling requests
 func (gb *gearbox) Start(address string) error {
 	// Setup router
-	gb.setupRouter()
+	if err := gb.Build(); err != nil {
+		return err
+	}
+
+	// Router is not changed while serving requests
+	atomic.StoreUint32(&gb.started, 1)
 
 	if gb.settings.Prefork {
 		if !gb.settings.DisableStartupMessage {
-			printStartupMessage(address)
+			gb.printStartupMessage(address)
 		}
 
 		pf := prefork.New(gb.httpServer)
@@ -324,7 +419,7 @@ func (gb *gearbox) Start(address string) error {
 	gb.address = address
 
 	if !gb.settings.DisableStartupMessage {
-		printStartupMessage(address)
+		gb.printStartupMessage(address)
 	}
 
 	if gb.settings.TLSEnabled {
@@ -341,9 +436,14 @@ func (dl *customLogger) Printf(format string, args ...interface{}) {
 
 // newHTTPServer returns a new instance of fasthttp server
 func (gb *gearbox) newHTTPServer() *fasthttp.Server {
+	var logger fasthttp.Logger = &customLogger{}
+	if gb.settings.LogServerErrors {
+		logger = &serverLogger{logger: gb.settings.Logger}
+	}
+
 	return &fasthttp.Server{
 		Handler:                       gb.router.Handler,
-		Logger:                        &customLogger{},
+		Logger:                        logger,
 		LogAllErrors:                  false,
 		Name:                          gb.settings.ServerName,
 		Concurrency:                   gb.settings.Concurrency,
@@ -361,10 +461,6 @@ func (gb *gearbox) newHTTPServer() *fasthttp.Server {
 
 // registerRoute registers handlers with method and path
 func (gb *gearbox) registerRoute(method, path string, handlers handlersChain) *Route {
-	if gb.settings.CaseInSensitive {
-		path = strings.ToLower(path)
-	}
-
 	route := &Route{
 		Path:     path,
 		Method:   method,
@@ -376,15 +472,80 @@ func (gb *gearbox) registerRoute(method, path string, handlers handlersChain) *R
 	return route
 }
 
-// setupRouter initializes router with registered routes
-func (gb *gearbox) setupRouter() {
-	for _, route := range gb.registeredRoutes {
-		gb.router.handle(route.Method, route.Path, append(gb.middlewares, route.Handlers...))
+// Build initializes router with registered routes, it validates all routes
+// and returns RouteErrors describing every invalid route, invalid routes
+// are not served. It's called by Start, calling it before is useful to
+// report problems of routes without starting service. It can be called
+// again before starting service to add routes registered later, errors of
+// all invalid routes are returned. It returns an error after starting
+// service since router can not be changed while serving requests
+func (gb *gearbox) Build() error {
+	if atomic.LoadUint32(&gb.started) == 1 {
+		return errors.New("routes can not be built after starting service")
+	}
+
+	for i, route := range gb.registeredRoutes[gb.routerRoutes:] {
+		if route.name != "" && isNameUsed(route, gb.registeredRoutes[:gb.routerRoutes+i]) {
+			gb.routeErrors = append(gb.routeErrors, newRouteError(route,
+				errors.New("route name '"+route.name+"' is already used by another route")))
+			continue
+		}
+
+		route.chain = gb.routeHandlers(route)
+		if err := gb.router.handleRoute(route, route.chain); err != nil {
+			gb.routeErrors = append(gb.routeErrors, newRouteError(route, err))
+		}
 	}
 
-	// Frees intermediate stores after initializing router
-	gb.registeredRoutes = nil
-	gb.middlewares = nil
+	// Registered routes are kept to build URLs of named routes and to list
+	// them, only routes registered later are added to router if it's
+	// built again
+	gb.routerRoutes = len(gb.registeredRoutes)
+
+	gb.router.setupFallbacks(gb.middlewares)
+
+	// Cached matches may be shadowed by routes added in this build
+	if gb.router.cache != nil {
+		gb.router.cache.clear()
+	}
+
+	if len(gb.routeErrors) > 0 {
+		return gb.routeErrors
+	}
+	return nil
+}
+
+// isNameUsed checks if one of routes has the route's name with another
+// path or host, routes of the same path can share a name since their URLs
+// are the same, e.g. routes registered by Any
+func isNameUsed(route *Route, routes []*Route) bool {
+	for _, r := range routes {
+		if r.name == route.name && (r.Path != route.Path || r.Host != route.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRouteError returns error of the invalid route
+func newRouteError(route *Route, err error) *RouteError {
+	return &RouteError{
+		Method: route.Method,
+		Path:   route.Path,
+		Host:   route.Host,
+		Err:    err,
+	}
+}
+
+// routeHandlers returns handlers chain of the route, global middlewares run
+// first, then middlewares of route's groups and middlewares of the route
+func (gb *gearbox) routeHandlers(route *Route) handlersChain {
+	handlers := append(handlersChain{}, gb.middlewares...)
+	if route.group != nil {
+		handlers = route.group.appendMiddlewares(handlers)
+	}
+	handlers = append(handlers, route.middlewares...)
+	return append(handlers, route.Handlers...)
 }
 
 // Stop serving
@@ -393,7 +554,7 @@ func (gb *gearbox) Stop() error {
 
 	// check if shutdown was ok and server had valid address
 	if err == nil && gb.address != "" {
-		log.Printf("%s stopped listening on %s", Name, gb.address)
+		gb.settings.Logger.Info(Name+" stopped listening", "address", gb.address)
 		return nil
 	}
 
@@ -445,20 +606,43 @@ func (gb *gearbox) Trace(path string, handlers ...handlerFunc) *Route {
 	return gb.registerRoute(MethodTrace, path, handlers)
 }
 
-// Group appends a prefix to registered routes
-func (gb *gearbox) Group(prefix string, routes []*Route) []*Route {
+// Any registers handlers for all standard http methods
+func (gb *gearbox) Any(path string, handlers ...handlerFunc) []*Route {
+	return gb.Match(standardMethods, path, handlers...)
+}
+
+// Match registers handlers for each method of methods, they can be custom
+// methods, e.g. PROPFIND
+func (gb *gearbox) Match(methods []string, path string, handlers ...handlerFunc) []*Route {
+	routes := make([]*Route, len(methods))
+	for i, method := range methods {
+		routes[i] = gb.registerRoute(method, path, handlers)
+	}
+	return routes
+}
+
+// Group creates a group of routes that share the prefix, groups can be nested
+// and have their own middlewares
+func (gb *gearbox) Group(prefix string) Group {
+	return &group{
+		gb:     gb,
+		prefix: prefix,
+	}
+}
+
+// Host restricts registered routes to requests with host matching the pattern,
+// labels starting with ':' match any label and are accessible as params,
+// e.g. :tenant.example.com. Requests of other hosts fall back to routes
+// registered without a host pattern
+func (gb *gearbox) Host(pattern string, routes []*Route) []*Route {
 	for _, route := range routes {
-		route.Path = prefix + route.Path
+		route.Host = pattern
 	}
 	return routes
 }
 
 // Static serves files in root directory under specific prefix
 func (gb *gearbox) Static(prefix, root string) {
-	if gb.settings.CaseInSensitive {
-		prefix = strings.ToLower(prefix)
-	}
-
 	// remove trailing slash
 	if len(root) > 1 && root[len(root)-1] == '/' {
 		root = root[:len(root)-1]
@@ -485,28 +669,27 @@ func (gb *gearbox) Static(prefix, root string) {
 			}
 			return path
 		},
+		// Pass to custom not found handlers if there are without resetting
+		// response, middlewares are already executed and their headers are kept
+		PathNotFound: func(fctx *fasthttp.RequestCtx) {
+			if ctx, ok := fctx.UserValue(staticContextKey).(*context); ok {
+				ctx.run(gb.router.notFound, handleNotFound)
+			}
+		},
 	}
 
 	fileHandler := fs.NewRequestHandler()
 	handler := func(ctx Context) {
 		fctx := ctx.Context()
+		fctx.SetUserValue(staticContextKey, ctx)
 
 		fileHandler(fctx)
 
-		status := fctx.Response.StatusCode()
-		if status != StatusNotFound && status != StatusForbidden {
-			return
+		// Forbidden directories are answered by file handler after resetting
+		// response, they are passed to not found handlers as well
+		if fctx.Response.StatusCode() == StatusForbidden {
+			ctx.(*context).run(gb.router.notFound, handleNotFound)
 		}
-
-		// Pass to custom not found handlers if there are
-		if gb.router.notFound != nil {
-			gb.router.notFound[0](ctx)
-			return
-		}
-
-		// Default Not Found response
-		fctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound),
-			fasthttp.StatusNotFound)
 	}
 
 	// TODO: Improve
@@ -531,12 +714,22 @@ func (gb *gearbox) Use(middlewares ...handlerFunc) {
 	gb.middlewares = append(gb.middlewares, middlewares...)
 }
 
-// printStartupMessage prints gearbox info log message in parent process
-// and prints process id for child process
-func printStartupMessage(addr string) {
+// CacheStats returns hits, misses and entries count of routing cache
+func (gb *gearbox) CacheStats() CacheStats {
+	return gb.router.CacheStats()
+}
+
+// printStartupMessage logs gearbox version and address in parent process
+// and logs process id for child process, banner is printed before the
+// message only if default logger is used since it's not a log message
+func (gb *gearbox) printStartupMessage(addr string) {
 	if prefork.IsChild() {
-		log.Printf("Started child proc #%v\n", os.Getpid())
-	} else {
-		log.Printf(banner, Version, addr)
+		gb.settings.Logger.Info("Started child process", "pid", os.Getpid())
+		return
+	}
+
+	if gb.settings.Logger == defaultLogger {
+		log.Printf(banner, Version)
 	}
+	gb.settings.Logger.Info(Name+" started listening", "version", Version, "address", addr)
 }
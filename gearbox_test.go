@@ -40,6 +40,16 @@ func (c *fakeConn) Write(b []byte) (int, error) {
 	return c.w.Write(b)
 }
 
+// LocalAddr returns the local network address
+func (c *fakeConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+}
+
+// RemoteAddr returns the remote network address
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
 // setupGearbox returns instace of gearbox struct
 func setupGearbox(settings ...*Settings) *gearbox {
 	gb := new(gearbox)
@@ -53,7 +63,6 @@ func setupGearbox(settings ...*Settings) *gearbox {
 
 	gb.router = &router{
 		settings: gb.settings,
-		cache:    make(map[string]*matchResult),
 		pool: sync.Pool{
 			New: func() interface{} {
 				return new(context)
@@ -61,17 +70,22 @@ func setupGearbox(settings ...*Settings) *gearbox {
 		},
 	}
 
+	if !gb.settings.DisableCaching {
+		gb.router.cache = newRouteCache(gb.settings.CacheSize)
+	}
+
 	return gb
 }
 
 // startGearbox constructs routing tree and creates server
-func startGearbox(gb *gearbox) {
-	gb.setupRouter()
+func startGearbox(gb *gearbox) error {
+	err := gb.Build()
 	gb.httpServer = &fasthttp.Server{
 		Handler:      gb.router.Handler,
 		Logger:       &customLogger{},
 		LogAllErrors: false,
 	}
+	return err
 }
 
 // emptyHandler just an empty handler
@@ -248,7 +262,7 @@ func TestMethods(t *testing.T) {
 	}{
 		{method: MethodGet, path: "/order/get?name=art123", statusCode: StatusOK, body: "art123"},
 		{method: MethodPost, path: "/order/add", requestBody: "testOrder", statusCode: StatusOK, body: "testOrder"},
-		{method: MethodPost, path: "/books/find", statusCode: StatusMethodNotAllowed, body: "Method Not Allowed", headers: map[string]string{"Allow": "GET, OPTIONS"}},
+		{method: MethodPost, path: "/books/find", statusCode: StatusMethodNotAllowed, body: "Method Not Allowed", headers: map[string]string{"Allow": "GET, HEAD, OPTIONS"}},
 		{method: MethodGet, path: "/articles/search", statusCode: StatusOK},
 		{method: MethodGet, path: "/articles/search", statusCode: StatusOK},
 		{method: MethodGet, path: "/Articles/search", statusCode: StatusOK},
@@ -503,12 +517,36 @@ func TestNotFound(t *testing.T) {
 func TestGroupRouting(t *testing.T) {
 	// create gearbox instance
 	gb := setupGearbox()
-	routes := []*Route{
-		gb.Get("/id", emptyHandler),
-		gb.Post("/abc", emptyHandler),
-		gb.Post("/abcd", emptyHandler),
+	gb.Use(func(ctx Context) {
+		ctx.Set("X-Order", "global")
+		ctx.Next()
+	})
+
+	// middleware appends name to order header
+	middleware := func(name string) handlerFunc {
+		return func(ctx Context) {
+			order := string(ctx.Context().Response.Header.Peek("X-Order"))
+			ctx.Set("X-Order", order+","+name)
+			ctx.Next()
+		}
 	}
-	gb.Group("/account", gb.Group("/api", routes))
+
+	account := gb.Group("/account")
+	account.Use(middleware("account"))
+
+	api := account.Group("/api")
+	api.Use(middleware("api"))
+	api.Get("/id", emptyHandler)
+	api.Post("/abc", emptyHandler)
+	api.Post("/abcd", emptyHandler)
+
+	account.Get("/info", emptyHandler)
+
+	// Sibling group does not use middlewares of other groups
+	gb.Group("/public").Get("/id", emptyHandler)
+
+	// Middlewares registered after routes are applied as well
+	api.Use(middleware("late"))
 
 	// start serving
 	startGearbox(gb)
@@ -519,11 +557,14 @@ func TestGroupRouting(t *testing.T) {
 		path       string
 		statusCode int
 		body       string
+		order      string
 	}{
-		{method: MethodGet, path: "/account/api/id", statusCode: StatusOK},
-		{method: MethodPost, path: "/account/api/abc", statusCode: StatusOK},
-		{method: MethodPost, path: "/account/api/abcd", statusCode: StatusOK},
-		{method: MethodGet, path: "/id", statusCode: StatusNotFound, body: "Not Found"},
+		{method: MethodGet, path: "/account/api/id", statusCode: StatusOK, order: "global,account,api,late"},
+		{method: MethodPost, path: "/account/api/abc", statusCode: StatusOK, order: "global,account,api,late"},
+		{method: MethodPost, path: "/account/api/abcd", statusCode: StatusOK, order: "global,account,api,late"},
+		{method: MethodGet, path: "/account/info", statusCode: StatusOK, order: "global,account"},
+		{method: MethodGet, path: "/public/id", statusCode: StatusOK, order: "global"},
+		{method: MethodGet, path: "/id", statusCode: StatusNotFound, body: "Not Found", order: "global"},
 	}
 
 	for _, tc := range testCases {
@@ -550,6 +591,11 @@ func TestGroupRouting(t *testing.T) {
 		if string(body) != tc.body {
 			t.Fatalf("%s(%s): returned %s expected %s", tc.method, tc.path, body, tc.body)
 		}
+
+		// check order of executed middlewares
+		if order := response.Header.Get("X-Order"); order != tc.order {
+			t.Errorf("%s(%s): executed middlewares %s expected %s", tc.method, tc.path, order, tc.order)
+		}
 	}
 }
 
@@ -579,3 +625,229 @@ func TestUse(t *testing.T) {
 		t.Fatalf("%s(%s): returned %d expected %d", MethodGet, "/ping", response.StatusCode, StatusUnauthorized)
 	}
 }
+
+// TestHostRouting tests routing requests by their host
+func TestHostRouting(t *testing.T) {
+	gb := setupGearbox(&Settings{
+		HandleMethodNotAllowed: true,
+	})
+
+	gb.Host("api.example.com", []*Route{
+		gb.Get("/users", func(ctx Context) {
+			ctx.SendString("api users")
+		}),
+	})
+	gb.Host(":tenant.example.com", []*Route{
+		gb.Get("/users", func(ctx Context) {
+			ctx.SendString(ctx.Param("tenant") + " users")
+		}),
+		gb.Post("/users/:id", func(ctx Context) {
+			ctx.SendString(ctx.Param("tenant") + " " + ctx.Param("id"))
+		}),
+		gb.Get("/billing", func(ctx Context) {
+			ctx.SendString(ctx.Param("tenant") + " billing")
+		}),
+	})
+	gb.Host(":Shop.Store.example.com", []*Route{
+		gb.Get("/orders", func(ctx Context) {
+			ctx.SendString(ctx.Param("Shop") + " orders")
+		}),
+	})
+	gb.Get("/users", func(ctx Context) {
+		ctx.SendString("users")
+	})
+	gb.Get("/health", func(ctx Context) {
+		ctx.SendString("ok")
+	})
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		method     string
+		host       string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{method: MethodGet, host: "api.example.com", path: "/users", statusCode: StatusOK, body: "api users"},
+		{method: MethodGet, host: "API.example.com:8080", path: "/users", statusCode: StatusOK, body: "api users"},
+		{method: MethodGet, host: "acme.example.com", path: "/users", statusCode: StatusOK, body: "acme users"},
+		{method: MethodGet, host: "globex.example.com", path: "/users", statusCode: StatusOK, body: "globex users"},
+		{method: MethodPost, host: "acme.example.com", path: "/users/5", statusCode: StatusOK, body: "acme 5"},
+		{method: MethodGet, host: "acme.example.com", path: "/health", statusCode: StatusOK, body: "ok"},
+		{method: MethodGet, host: "example.com", path: "/users", statusCode: StatusOK, body: "users"},
+		{method: MethodGet, host: "localhost", path: "/users", statusCode: StatusOK, body: "users"},
+		{method: MethodGet, host: "acme.example.com", path: "/users/5", statusCode: StatusMethodNotAllowed,
+			body: "Method Not Allowed"},
+		{method: MethodPost, host: "localhost", path: "/users/5", statusCode: StatusNotFound, body: "Not Found"},
+		{method: MethodGet, host: "api.example.com", path: "/billing", statusCode: StatusOK, body: "api billing"},
+		{method: MethodPost, host: "api.example.com", path: "/users/5", statusCode: StatusOK, body: "api 5"},
+		{method: MethodGet, host: "api.example.com", path: "/users/5", statusCode: StatusMethodNotAllowed,
+			body: "Method Not Allowed"},
+		{method: MethodGet, host: "api.example.com", path: "/health", statusCode: StatusOK, body: "ok"},
+		{method: MethodGet, host: "acme.store.example.com", path: "/orders", statusCode: StatusOK, body: "acme orders"},
+	}
+
+	// Requesting twice to test cached matches
+	for i := 0; i < 2; i++ {
+		for _, tc := range testCases {
+			req, _ := http.NewRequest(tc.method, tc.path, nil)
+			req.Host = tc.host
+			response, err := makeRequest(req, gb)
+			if err != nil {
+				t.Fatalf("%s(%s%s): %s", tc.method, tc.host, tc.path, err.Error())
+			}
+
+			if response.StatusCode != tc.statusCode {
+				t.Errorf("%s(%s%s): returned %d expected %d", tc.method, tc.host, tc.path,
+					response.StatusCode, tc.statusCode)
+			}
+
+			body, _ := ioutil.ReadAll(response.Body)
+			if string(body) != tc.body {
+				t.Errorf("%s(%s%s): returned %s expected %s", tc.method, tc.host, tc.path, body, tc.body)
+			}
+		}
+	}
+}
+
+// TestAnyAndMatch tests registering handlers for multiple methods
+func TestAnyAndMatch(t *testing.T) {
+	gb := setupGearbox(&Settings{
+		HandleMethodNotAllowed: true,
+	})
+
+	methodHandler := func(ctx Context) {
+		ctx.SendString(string(ctx.Context().Method()))
+	}
+
+	if routes := gb.Any("/any", methodHandler); len(routes) != len(standardMethods) {
+		t.Errorf("Any registered %d routes expected %d", len(routes), len(standardMethods))
+	}
+	gb.Match([]string{MethodGet, MethodPost}, "/match", methodHandler)
+	gb.Match([]string{"PROPFIND", "MKCOL"}, "/dav/*path", methodHandler)
+	gb.Group("/api").Any("/ping", methodHandler)
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{method: MethodGet, path: "/any", statusCode: StatusOK, body: MethodGet},
+		{method: MethodPost, path: "/any", statusCode: StatusOK, body: MethodPost},
+		{method: MethodDelete, path: "/any", statusCode: StatusOK, body: MethodDelete},
+		{method: MethodTrace, path: "/any", statusCode: StatusOK, body: MethodTrace},
+		{method: MethodPatch, path: "/api/ping", statusCode: StatusOK, body: MethodPatch},
+		{method: MethodGet, path: "/match", statusCode: StatusOK, body: MethodGet},
+		{method: MethodPost, path: "/match", statusCode: StatusOK, body: MethodPost},
+		{method: MethodPut, path: "/match", statusCode: StatusMethodNotAllowed, body: "Method Not Allowed"},
+		{method: "PROPFIND", path: "/dav/docs/a.txt", statusCode: StatusOK, body: "PROPFIND"},
+		{method: "MKCOL", path: "/dav/docs", statusCode: StatusOK, body: "MKCOL"},
+		{method: MethodGet, path: "/dav/docs", statusCode: StatusMethodNotAllowed, body: "Method Not Allowed"},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", tc.method, tc.path, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s(%s): returned %d expected %d", tc.method, tc.path, response.StatusCode, tc.statusCode)
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != tc.body {
+			t.Errorf("%s(%s): returned %s expected %s", tc.method, tc.path, body, tc.body)
+		}
+	}
+}
+
+// TestFallbackMiddlewares tests executing middlewares for requests that do
+// not match any route
+func TestFallbackMiddlewares(t *testing.T) {
+	testCases := []struct {
+		notFound   []handlerFunc
+		method     string
+		path       string
+		statusCode int
+		body       string
+		allow      string
+	}{
+		{method: MethodGet, path: "/unknown", statusCode: StatusNotFound, body: "Not Found"},
+		{notFound: []handlerFunc{emptyMiddleware, fallbackHandler}, method: MethodGet, path: "/unknown",
+			statusCode: StatusNotFound, body: "custom fallback handler"},
+		{method: MethodPost, path: "/users", statusCode: StatusMethodNotAllowed, body: "Method Not Allowed",
+			allow: "GET, HEAD, OPTIONS"},
+		{method: MethodOptions, path: "/users", statusCode: StatusOK, allow: "GET, HEAD, OPTIONS"},
+		{method: MethodGet, path: "/static/unknown.txt", statusCode: StatusNotFound, body: "Not Found"},
+		{notFound: []handlerFunc{emptyMiddleware, fallbackHandler}, method: MethodGet, path: "/static/unknown.txt",
+			statusCode: StatusNotFound, body: "custom fallback handler"},
+	}
+
+	for _, tc := range testCases {
+		gb := setupGearbox(&Settings{
+			HandleMethodNotAllowed: true,
+			HandleOPTIONS:          true,
+		})
+
+		gb.Use(func(ctx Context) {
+			ctx.Set("X-Middleware", "global")
+			ctx.Next()
+		})
+		gb.Get("/users", emptyHandler)
+		gb.Static("/static", "./assets")
+		if tc.notFound != nil {
+			gb.NotFound(tc.notFound...)
+		}
+
+		startGearbox(gb)
+
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", tc.method, tc.path, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s(%s): returned %d expected %d", tc.method, tc.path, response.StatusCode, tc.statusCode)
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != tc.body {
+			t.Errorf("%s(%s): returned %s expected %s", tc.method, tc.path, body, tc.body)
+		}
+
+		if allow := response.Header.Get("Allow"); allow != tc.allow {
+			t.Errorf("%s(%s): returned Allow %s expected %s", tc.method, tc.path, allow, tc.allow)
+		}
+
+		if header := response.Header.Get("X-Middleware"); header != "global" {
+			t.Errorf("%s(%s): middleware is not executed", tc.method, tc.path)
+		}
+	}
+}
+
+// TestFallbackMiddlewaresStop tests middlewares answering requests that do
+// not match any route without executing not found handlers
+func TestFallbackMiddlewaresStop(t *testing.T) {
+	gb := setupGearbox()
+	gb.Use(unAuthorizedHandler)
+	gb.NotFound(fallbackHandler)
+
+	startGearbox(gb)
+
+	req, _ := http.NewRequest(MethodGet, "/unknown", nil)
+	response, err := makeRequest(req, gb)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if response.StatusCode != StatusUnauthorized {
+		t.Errorf("returned %d expected %d", response.StatusCode, StatusUnauthorized)
+	}
+}
@@ -0,0 +1,119 @@
+package gearbox
+
+// Group interface for routes sharing a path prefix and middlewares
+type Group interface {
+	Get(path string, handlers ...handlerFunc) *Route
+	Head(path string, handlers ...handlerFunc) *Route
+	Post(path string, handlers ...handlerFunc) *Route
+	Put(path string, handlers ...handlerFunc) *Route
+	Patch(path string, handlers ...handlerFunc) *Route
+	Delete(path string, handlers ...handlerFunc) *Route
+	Connect(path string, handlers ...handlerFunc) *Route
+	Options(path string, handlers ...handlerFunc) *Route
+	Trace(path string, handlers ...handlerFunc) *Route
+	Any(path string, handlers ...handlerFunc) []*Route
+	Match(methods []string, path string, handlers ...handlerFunc) []*Route
+	Group(prefix string) Group
+	Use(middlewares ...handlerFunc)
+}
+
+// group implements Group interface
+type group struct {
+	gb          *gearbox
+	parent      *group
+	prefix      string // full prefix including prefixes of parent groups
+	middlewares handlersChain
+}
+
+// registerRoute registers handlers with method and path under group's prefix
+func (g *group) registerRoute(method, path string, handlers handlersChain) *Route {
+	route := g.gb.registerRoute(method, g.prefix+path, handlers)
+	route.group = g
+	return route
+}
+
+// appendMiddlewares appends middlewares of group and its parents to handlers
+// starting with the outermost group
+func (g *group) appendMiddlewares(handlers handlersChain) handlersChain {
+	if g.parent != nil {
+		handlers = g.parent.appendMiddlewares(handlers)
+	}
+	return append(handlers, g.middlewares...)
+}
+
+// Get registers an http relevant method
+func (g *group) Get(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodGet, path, handlers)
+}
+
+// Head registers an http relevant method
+func (g *group) Head(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodHead, path, handlers)
+}
+
+// Post registers an http relevant method
+func (g *group) Post(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodPost, path, handlers)
+}
+
+// Put registers an http relevant method
+func (g *group) Put(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodPut, path, handlers)
+}
+
+// Patch registers an http relevant method
+func (g *group) Patch(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodPatch, path, handlers)
+}
+
+// Delete registers an http relevant method
+func (g *group) Delete(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodDelete, path, handlers)
+}
+
+// Connect registers an http relevant method
+func (g *group) Connect(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodConnect, path, handlers)
+}
+
+// Options registers an http relevant method
+func (g *group) Options(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodOptions, path, handlers)
+}
+
+// Trace registers an http relevant method
+func (g *group) Trace(path string, handlers ...handlerFunc) *Route {
+	return g.registerRoute(MethodTrace, path, handlers)
+}
+
+// Any registers handlers for all standard http methods
+func (g *group) Any(path string, handlers ...handlerFunc) []*Route {
+	return g.Match(standardMethods, path, handlers...)
+}
+
+// Match registers handlers for each method of methods, they can be custom
+// methods, e.g. PROPFIND
+func (g *group) Match(methods []string, path string, handlers ...handlerFunc) []*Route {
+	routes := make([]*Route, len(methods))
+	for i, method := range methods {
+		routes[i] = g.registerRoute(method, path, handlers)
+	}
+	return routes
+}
+
+// Group creates a nested group with prefix appended to group's prefix,
+// its routes are handled by middlewares of group and nested group
+func (g *group) Group(prefix string) Group {
+	return &group{
+		gb:     g.gb,
+		parent: g,
+		prefix: g.prefix + prefix,
+	}
+}
+
+// Use attaches middlewares to the group, they are executed after global
+// middlewares and middlewares of parent groups for routes of the group
+// and its nested groups only
+func (g *group) Use(middlewares ...handlerFunc) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
@@ -0,0 +1,34 @@
+package gearbox
+
+import (
+	"testing"
+)
+
+// TestGroupPrefix tests paths of routes registered in nested groups
+func TestGroupPrefix(t *testing.T) {
+	gb := setupGearbox()
+
+	api := gb.Group("/api")
+	v1 := api.Group("/v1")
+	users := v1.Group("/users")
+
+	testCases := []struct {
+		route *Route
+		path  string
+	}{
+		{route: api.Get("/status", emptyHandler), path: "/api/status"},
+		{route: v1.Post("/login", emptyHandler), path: "/api/v1/login"},
+		{route: users.Get("/:id", emptyHandler), path: "/api/v1/users/:id"},
+		{route: users.Delete("", emptyHandler), path: "/api/v1/users"},
+	}
+
+	for _, tc := range testCases {
+		if tc.route.Path != tc.path {
+			t.Errorf("route path is %s expected %s", tc.route.Path, tc.path)
+		}
+	}
+
+	if len(gb.registeredRoutes) != len(testCases) {
+		t.Errorf("registered %d routes expected %d", len(gb.registeredRoutes), len(testCases))
+	}
+}
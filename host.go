@@ -0,0 +1,100 @@
+package gearbox
+
+import (
+	"errors"
+	"strings"
+)
+
+// hostTrees holds routing trees of routes registered for a host pattern
+type hostTrees struct {
+	pattern string
+	labels  []string
+	params  int
+	trees   map[string]*node
+}
+
+// newHostTrees parses host pattern into its labels, labels starting with ':'
+// are parameters that match any label, e.g. :tenant.example.com. Literal
+// labels are lowered since hosts are case-insensitive, names of params keep
+// their case
+func newHostTrees(pattern string) (*hostTrees, error) {
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if label == "" || label[0] != ':' {
+			labels[i] = strings.ToLower(label)
+		}
+	}
+	pattern = strings.Join(labels, ".")
+
+	h := &hostTrees{
+		pattern: pattern,
+		labels:  labels,
+		trees:   make(map[string]*node),
+	}
+
+	for _, label := range h.labels {
+		if label == "" {
+			return nil, errors.New("host pattern '" + pattern + "' has an empty label")
+		}
+
+		if label[0] == ':' {
+			if len(label) == 1 {
+				return nil, errors.New("parameter in host pattern '" + pattern +
+					"' must have a name")
+			}
+			h.params++
+		}
+	}
+
+	return h, nil
+}
+
+// match checks if host matches the pattern and stores values of pattern's
+// params in context if it's provided
+func (h *hostTrees) match(host string, ctx *context) bool {
+	for i, label := range h.labels {
+		end := strings.IndexByte(host, '.')
+		if end == -1 {
+			if i != len(h.labels)-1 {
+				return false
+			}
+			end = len(host)
+		} else if i == len(h.labels)-1 {
+			return false
+		}
+
+		if label[0] == ':' {
+			if end == 0 {
+				return false
+			}
+
+			if ctx != nil {
+				ctx.setParam(label[1:], host[:end])
+			}
+		} else if !equalFoldASCII(label, host[:end]) {
+			return false
+		}
+
+		if end < len(host) {
+			host = host[end+1:]
+		}
+	}
+
+	return true
+}
+
+// hostname returns host of request without port
+func hostname(host string) string {
+	// IPv6 address, e.g. [::1]:8080
+	if len(host) > 0 && host[0] == '[' {
+		if end := strings.IndexByte(host, ']'); end != -1 {
+			return host[:end+1]
+		}
+		return host
+	}
+
+	if end := strings.IndexByte(host, ':'); end != -1 {
+		return host[:end]
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+package gearbox
+
+import (
+	"testing"
+)
+
+// TestNewHostTrees tests parsing host patterns
+func TestNewHostTrees(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		labels  int
+		params  int
+		isErr   bool
+	}{
+		{pattern: "example.com", labels: 2, params: 0},
+		{pattern: "API.Example.com", labels: 3, params: 0},
+		{pattern: ":tenant.example.com", labels: 3, params: 1},
+		{pattern: ":tenant.:region.example.com", labels: 4, params: 2},
+		{pattern: "localhost", labels: 1, params: 0},
+		{pattern: "", isErr: true},
+		{pattern: "example..com", isErr: true},
+		{pattern: ".example.com", isErr: true},
+		{pattern: ":.example.com", isErr: true},
+	}
+
+	for _, tc := range testCases {
+		h, err := newHostTrees(tc.pattern)
+		if err != nil {
+			if !tc.isErr {
+				t.Errorf("host pattern %s: unexpected error %v", tc.pattern, err)
+			}
+			continue
+		} else if tc.isErr {
+			t.Errorf("host pattern %s: expected error", tc.pattern)
+			continue
+		}
+
+		if len(h.labels) != tc.labels || h.params != tc.params {
+			t.Errorf("host pattern %s: returned %d labels and %d params expected %d and %d",
+				tc.pattern, len(h.labels), h.params, tc.labels, tc.params)
+		}
+	}
+}
+
+// TestHostTreesMatch tests matching hosts against host patterns
+func TestHostTreesMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		host    string
+		match   bool
+		params  map[string]string
+	}{
+		{pattern: "example.com", host: "example.com", match: true},
+		{pattern: "example.com", host: "EXAMPLE.com", match: true},
+		{pattern: "example.com", host: "api.example.com", match: false},
+		{pattern: "api.example.com", host: "example.com", match: false},
+		{pattern: "example.com", host: "example.org", match: false},
+		{pattern: "example.com", host: "", match: false},
+		{pattern: ":tenant.example.com", host: "acme.example.com", match: true,
+			params: map[string]string{"tenant": "acme"}},
+		{pattern: ":tenant.:region.example.com", host: "acme.eu.example.com", match: true,
+			params: map[string]string{"tenant": "acme", "region": "eu"}},
+		{pattern: ":Tenant.Example.com", host: "acme.example.COM", match: true,
+			params: map[string]string{"Tenant": "acme"}},
+		{pattern: ":tenant.example.com", host: "example.com", match: false},
+		{pattern: ":tenant.example.com", host: ".example.com", match: false},
+		{pattern: ":tenant.example.com", host: "a.b.example.com", match: false},
+	}
+
+	for _, tc := range testCases {
+		h, _ := newHostTrees(tc.pattern)
+		ctx := &context{}
+
+		if match := h.match(tc.host, ctx); match != tc.match {
+			t.Errorf("host %s with pattern %s: returned %t expected %t", tc.host, tc.pattern, match, tc.match)
+			continue
+		}
+
+		for key, value := range tc.params {
+			if ctx.Param(key) != value {
+				t.Errorf("host %s with pattern %s: param %s is %s expected %s",
+					tc.host, tc.pattern, key, ctx.Param(key), value)
+			}
+		}
+	}
+}
+
+// TestHostname tests removing port from hosts
+func TestHostname(t *testing.T) {
+	testCases := map[string]string{
+		"example.com":      "example.com",
+		"example.com:8080": "example.com",
+		"127.0.0.1:80":     "127.0.0.1",
+		"[::1]:8080":       "[::1]",
+		"[::1]":            "[::1]",
+		"":                 "",
+	}
+
+	for host, expected := range testCases {
+		if name := hostname(host); name != expected {
+			t.Errorf("hostname(%s): returned %s expected %s", host, name, expected)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package gearbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// LogLevel is the severity of log messages
+type LogLevel int
+
+// Log levels, messages with lower level than logger's level are dropped
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// String returns name of log level
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Logger interface is used by gearbox to log its messages, keyvals are
+// alternating keys and values that describe the message, e.g.
+// logger.Info("server stopped", "address", ":3000")
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// defaultLogger is used if Logger is not set in settings
+var defaultLogger = NewStdLogger(nil, LogLevelInfo)
+
+// stdLogger logs messages using standard library logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger returns a Logger that writes messages using standard library
+// logger as lines of level, message and key=value pairs, e.g.
+// [INFO] server stopped address=:3000. Standard logger of log package is
+// used if logger is nil
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	return &stdLogger{
+		logger: logger,
+		level:  level,
+	}
+}
+
+// Debug logs message with debug level
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+// Info logs message with info level
+func (l *stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+// Warn logs message with warn level
+func (l *stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+// Error logs message with error level
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+// log formats message with its key/value pairs and writes it
+func (l *stdLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString("] ")
+	b.WriteString(msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(logValue(keyvals, i+1)))
+	}
+
+	if l.logger == nil {
+		_ = log.Output(3, b.String())
+		return
+	}
+	_ = l.logger.Output(3, b.String())
+}
+
+// formatLogValue formats value of key/value pair, it's quoted if it
+// contains spaces or characters that make line ambiguous
+func formatLogValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\r\n=\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logValue returns value of key at index i-1, missing values are nil
+func logValue(keyvals []interface{}, i int) interface{} {
+	if i < len(keyvals) {
+		return keyvals[i]
+	}
+	return nil
+}
+
+// jsonLogger logs messages as JSON objects separated by new lines
+type jsonLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  LogLevel
+}
+
+// NewJSONLogger returns a Logger that writes each message to writer as a
+// JSON object in a separate line holding time, level, msg and key/value
+// pairs, e.g. {"time":"...","level":"info","msg":"server stopped","address":":3000"}
+func NewJSONLogger(writer io.Writer, level LogLevel) Logger {
+	return &jsonLogger{
+		writer: writer,
+		level:  level,
+	}
+}
+
+// Debug logs message with debug level
+func (l *jsonLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+// Info logs message with info level
+func (l *jsonLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+// Warn logs message with warn level
+func (l *jsonLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+// Error logs message with error level
+func (l *jsonLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+// log encodes message with its key/value pairs and writes it as one line
+func (l *jsonLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	b.WriteString(strconv.Quote(time.Now().Format(time.RFC3339Nano)))
+	b.WriteString(`,"level":`)
+	b.WriteString(strconv.Quote(level.String()))
+	b.WriteString(`,"msg":`)
+	writeJSONValue(&b, json, msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(',')
+		writeJSONValue(&b, json, fmt.Sprint(keyvals[i]))
+		b.WriteByte(':')
+
+		value := logValue(keyvals, i+1)
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		writeJSONValue(&b, json, value)
+	}
+	b.WriteString("}\n")
+
+	l.mu.Lock()
+	_, _ = l.writer.Write(b.Bytes())
+	l.mu.Unlock()
+}
+
+// writeJSONValue writes value encoded as JSON, values that can not be
+// encoded are written as strings
+func writeJSONValue(b *bytes.Buffer, json jsoniter.API, value interface{}) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		raw, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(raw)
+}
+
+// serverLogger passes errors logged by fasthttp server to Logger
+type serverLogger struct {
+	logger Logger
+}
+
+// Printf logs fasthttp server's message with error level
+func (l *serverLogger) Printf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+}
+
+// loggerOf returns logger of settings or default logger if it's not set
+func loggerOf(settings *Settings) Logger {
+	if settings == nil || settings.Logger == nil {
+		return defaultLogger
+	}
+	return settings.Logger
+}
@@ -0,0 +1,163 @@
+package gearbox
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp/prefork"
+)
+
+// TestLogLevelString tests names of log levels
+func TestLogLevelString(t *testing.T) {
+	testCases := []struct {
+		level LogLevel
+		name  string
+	}{
+		{level: LogLevelDebug, name: "debug"},
+		{level: LogLevelInfo, name: "info"},
+		{level: LogLevelWarn, name: "warn"},
+		{level: LogLevelError, name: "error"},
+		{level: LogLevel(10), name: "level(10)"},
+	}
+
+	for _, tc := range testCases {
+		if name := tc.level.String(); name != tc.name {
+			t.Errorf("returned %s expected %s", name, tc.name)
+		}
+	}
+}
+
+// TestStdLogger tests formatting and filtering messages of standard logger
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogLevelInfo)
+
+	logger.Debug("dropped")
+	logger.Info("server started", "address", ":3000")
+	logger.Warn("slow request", "path", "/users", "latency", time.Second)
+	logger.Error("failed", "error", errors.New("bad request"), "query", "", "missing")
+
+	expected := "[INFO] server started address=:3000\n" +
+		"[WARN] slow request path=/users latency=1s\n" +
+		"[ERROR] failed error=\"bad request\" query=\"\" missing=<nil>\n"
+	if buf.String() != expected {
+		t.Errorf("logged %q expected %q", buf.String(), expected)
+	}
+}
+
+// TestJSONLogger tests encoding and filtering messages of JSON logger
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, LogLevelWarn)
+
+	logger.Info("dropped")
+	logger.Warn("slow request", "path", "/users", "status", 200, "latency", time.Second)
+	logger.Error("failed", "error", errors.New("bad request"), "body", make(chan int), "missing")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines expected %d: %s", len(lines), 2, buf.String())
+	}
+
+	expected := []map[string]interface{}{
+		{"level": "warn", "msg": "slow request", "path": "/users", "status": float64(200), "latency": "1s"},
+		{"level": "error", "msg": "failed", "error": "bad request", "missing": nil},
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	for i, line := range lines {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("logged invalid JSON %s: %s", line, err)
+		}
+
+		if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+			t.Errorf("logged invalid time %v", entry["time"])
+		}
+
+		for key, value := range expected[i] {
+			if entry[key] != value {
+				t.Errorf("logged %s=%v expected %v", key, entry[key], value)
+			}
+		}
+	}
+
+	if !strings.Contains(lines[1], `"body":"0x`) {
+		t.Errorf("logged %s expected body as string", lines[1])
+	}
+}
+
+// TestLoggerSettings tests logging messages of gearbox and fasthttp server
+func TestLoggerSettings(t *testing.T) {
+	var buf bytes.Buffer
+	gb := New(&Settings{
+		Logger:          NewStdLogger(log.New(&buf, "", 0), LogLevelInfo),
+		LogServerErrors: true,
+	}).(*gearbox)
+
+	gb.httpServer.Logger.Printf("error when serving connection %q", "127.0.0.1")
+
+	stopped := make(chan struct{})
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		gb.Stop()
+		close(stopped)
+	}()
+
+	gb.Start(":3020")
+	<-stopped
+
+	expectedMessages := []string{
+		"[ERROR] error when serving connection \"127.0.0.1\"\n",
+		"[INFO] Gearbox started listening version=" + Version + " address=:3020\n",
+		"[INFO] Gearbox stopped listening address=:3020\n",
+	}
+
+	// Tests run in child processes of prefork test log their process id
+	// instead of address
+	if prefork.IsChild() {
+		expectedMessages[1] = "[INFO] Started child process pid="
+	}
+
+	for _, expected := range expectedMessages {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("logged %q does not contain %q", buf.String(), expected)
+		}
+	}
+
+	// Banner is only printed if default logger is used
+	if strings.Contains(buf.String(), "_____") {
+		t.Errorf("logged %q contains banner", buf.String())
+	}
+}
+
+// TestRecoverLogging tests logging recovered panics through logger
+func TestRecoverLogging(t *testing.T) {
+	var buf bytes.Buffer
+	gb := setupGearbox(&Settings{
+		AutoRecover: true,
+		Logger:      NewStdLogger(log.New(&buf, "", 0), LogLevelInfo),
+	})
+
+	gb.Get("/panic", func(ctx Context) {
+		panic("unexpected")
+	})
+
+	startGearbox(gb)
+
+	req, _ := http.NewRequest(MethodGet, "/panic", nil)
+	if _, err := makeRequest(req, gb); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	expected := "[ERROR] recovered from panic error=unexpected method=GET path=/panic\n"
+	if buf.String() != expected {
+		t.Errorf("logged %q expected %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,93 @@
+package gearbox
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// Problem is a problem details document described by RFC 7807, extensions
+// are additional members rendered next to the standard ones
+type Problem struct {
+	Type       string // URI identifying problem type, default about:blank
+	Title      string // summary of problem type, default status text
+	Status     int    // HTTP status code, default 500
+	Detail     string // explanation of this occurrence of problem
+	Instance   string // URI identifying this occurrence, default request path
+	Extensions map[string]interface{}
+}
+
+// MarshalJSON encodes problem with its extension members, standard members
+// can not be overridden by extensions
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Marshal(members)
+}
+
+// writeProblem answers with problem document after filling its missing
+// standard members
+func writeProblem(fctx *fasthttp.RequestCtx, problem Problem) error {
+	if problem.Status == 0 {
+		problem.Status = StatusInternalServerError
+	}
+
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+
+	if problem.Title == "" {
+		problem.Title = fasthttp.StatusMessage(problem.Status)
+	}
+
+	if problem.Instance == "" {
+		problem.Instance = string(fctx.Path())
+	}
+
+	raw, err := problem.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	fctx.SetStatusCode(problem.Status)
+	fctx.Response.Header.SetContentType(MIMEApplicationProblemJSON)
+	fctx.Response.SetBodyRaw(raw)
+	return nil
+}
+
+// writeError answers with status code and message as plain text, or as
+// problem document if it's enabled in settings
+func writeError(fctx *fasthttp.RequestCtx, settings *Settings, code int, message string) {
+	title := fasthttp.StatusMessage(code)
+	if message == "" {
+		message = title
+	}
+
+	if settings != nil && settings.ProblemJSON {
+		problem := Problem{Status: code}
+		if message != title {
+			problem.Detail = message
+		}
+
+		if writeProblem(fctx, problem) == nil {
+			return
+		}
+	}
+
+	fctx.SetStatusCode(code)
+	fctx.SetContentTypeBytes(defaultContentType)
+	fctx.SetBodyString(message)
+}
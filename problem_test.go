@@ -0,0 +1,147 @@
+package gearbox
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestProblemMarshalJSON tests encoding problems with extension members
+func TestProblemMarshalJSON(t *testing.T) {
+	problem := Problem{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+		Extensions: map[string]interface{}{
+			"balance": 30,
+			"status":  200,
+		},
+	}
+
+	raw, err := problem.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(raw, &members); err != nil {
+		t.Fatalf("invalid JSON %s", raw)
+	}
+
+	expected := map[string]interface{}{
+		"type":     problem.Type,
+		"title":    problem.Title,
+		"status":   float64(StatusForbidden),
+		"detail":   problem.Detail,
+		"instance": problem.Instance,
+		"balance":  float64(30),
+	}
+
+	if len(members) != len(expected) {
+		t.Errorf("returned %d members expected %d", len(members), len(expected))
+	}
+
+	for key, value := range expected {
+		if members[key] != value {
+			t.Errorf("member %s is %v expected %v", key, members[key], value)
+		}
+	}
+}
+
+// TestProblemResponses tests answering framework-generated errors, errors
+// of handlers and problems sent by handlers as problem documents
+func TestProblemResponses(t *testing.T) {
+	gb := setupGearbox(&Settings{
+		ProblemJSON:            true,
+		HandleMethodNotAllowed: true,
+		AutoRecover:            true,
+		MaxRequestURLLength:    64,
+	})
+
+	gb.Get("/users/:id", Handle(func(ctx Context) error {
+		return NewHTTPError(StatusNotFound, "user "+ctx.Param("id")+" not found", nil)
+	}))
+	gb.Get("/internal", Handle(func(ctx Context) error {
+		return errors.New("database is down")
+	}))
+	gb.Get("/panic", func(ctx Context) {
+		ctx.Set("X-Partial", "true")
+		panic("unexpected")
+	})
+	gb.Get("/credit", func(ctx Context) {
+		ctx.SendProblem(Problem{
+			Type:       "https://example.com/probs/out-of-credit",
+			Status:     StatusForbidden,
+			Extensions: map[string]interface{}{"balance": 30},
+		})
+	})
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		method  string
+		path    string
+		problem map[string]interface{}
+	}{
+		{method: MethodGet, path: "/unknown", problem: map[string]interface{}{
+			"type": "about:blank", "title": "Not Found", "status": float64(404), "instance": "/unknown"}},
+		{method: MethodPost, path: "/internal", problem: map[string]interface{}{
+			"type": "about:blank", "title": "Method Not Allowed", "status": float64(405), "instance": "/internal"}},
+		{method: MethodGet, path: "/users/5", problem: map[string]interface{}{
+			"type": "about:blank", "title": "Not Found", "status": float64(404), "detail": "user 5 not found",
+			"instance": "/users/5"}},
+		{method: MethodGet, path: "/internal", problem: map[string]interface{}{
+			"type": "about:blank", "title": "Internal Server Error", "status": float64(500), "instance": "/internal"}},
+		{method: MethodGet, path: "/panic", problem: map[string]interface{}{
+			"type": "about:blank", "title": "Internal Server Error", "status": float64(500), "instance": "/panic"}},
+		{method: MethodGet, path: "/" + strings.Repeat("a", 64), problem: map[string]interface{}{
+			"type": "about:blank", "title": "Request URI Too Long", "status": float64(414),
+			"instance": "/" + strings.Repeat("a", 64)}},
+		{method: MethodGet, path: "/credit", problem: map[string]interface{}{
+			"type": "https://example.com/probs/out-of-credit", "title": "Forbidden", "status": float64(403),
+			"instance": "/credit", "balance": float64(30)}},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", tc.method, tc.path, err.Error())
+		}
+
+		if response.StatusCode != int(tc.problem["status"].(float64)) {
+			t.Errorf("%s(%s): returned %d expected %v", tc.method, tc.path, response.StatusCode, tc.problem["status"])
+		}
+
+		if contentType := response.Header.Get("Content-Type"); contentType != MIMEApplicationProblemJSON {
+			t.Errorf("%s(%s): returned content type %s expected %s", tc.method, tc.path, contentType,
+				MIMEApplicationProblemJSON)
+		}
+
+		if response.Header.Get("X-Partial") != "" {
+			t.Errorf("%s(%s): returned headers of recovered handler", tc.method, tc.path)
+		}
+
+		var problem map[string]interface{}
+		body, _ := ioutil.ReadAll(response.Body)
+		if err := json.Unmarshal(body, &problem); err != nil {
+			t.Fatalf("%s(%s): returned invalid JSON %s", tc.method, tc.path, body)
+		}
+
+		if len(problem) != len(tc.problem) {
+			t.Errorf("%s(%s): returned %s expected %v", tc.method, tc.path, body, tc.problem)
+			continue
+		}
+
+		for key, value := range tc.problem {
+			if problem[key] != value {
+				t.Errorf("%s(%s): member %s is %v expected %v", tc.method, tc.path, key, problem[key], value)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package gearbox
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/valyala/fasthttp"
+)
+
+// developmentRecoverPage is the HTML page rendered by DevelopmentRecoverHandler
+const developmentRecoverPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Internal Server Error</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #c0392b; }
+pre { background: #f4f4f4; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>Internal Server Error</h1>
+<p><strong>%s %s</strong></p>
+<h2>Panic</h2>
+<pre>%s</pre>
+<h2>Stack trace</h2>
+<pre>%s</pre>
+</body>
+</html>`
+
+// DefaultRecoverHandler logs recovered value and answers with HTTP status
+// code 500
+func DefaultRecoverHandler(ctx Context, recovered interface{}, stack []byte) {
+	fctx := ctx.Context()
+	loggerOf(ctx.(*context).settings).Error("recovered from panic", "error", recovered,
+		"method", string(fctx.Method()), "path", string(fctx.Path()))
+	ctx.(*context).sendError(fasthttp.StatusInternalServerError, "")
+}
+
+// DevelopmentRecoverHandler logs recovered value with stack trace and
+// answers with an HTML page showing them, it must not be used in production
+// since it exposes internals of service
+func DevelopmentRecoverHandler(ctx Context, recovered interface{}, stack []byte) {
+	fctx := ctx.Context()
+	loggerOf(ctx.(*context).settings).Error("recovered from panic", "error", recovered,
+		"method", string(fctx.Method()), "path", string(fctx.Path()), "stack", string(stack))
+
+	fctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	fctx.SetContentType("text/html; charset=utf-8")
+	fctx.SetBodyString(fmt.Sprintf(developmentRecoverPage,
+		html.EscapeString(string(fctx.Method())),
+		html.EscapeString(string(fctx.RequestURI())),
+		html.EscapeString(fmt.Sprint(recovered)),
+		html.EscapeString(string(stack))))
+}
@@ -0,0 +1,115 @@
+package gearbox
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRecoverHandler tests handling recovered panics
+func TestRecoverHandler(t *testing.T) {
+	var recovered interface{}
+	var stack []byte
+
+	gb := setupGearbox(&Settings{
+		AutoRecover: true,
+		RecoverHandler: func(ctx Context, rcv interface{}, s []byte) {
+			recovered, stack = rcv, s
+			ctx.Set("X-Request-ID", ctx.Get("X-Request-ID"))
+			ctx.Status(StatusServiceUnavailable).SendString("reported")
+		},
+	})
+
+	gb.Get("/panic", func(ctx Context) {
+		ctx.Set("X-Partial", "true")
+		panic("unexpected")
+	})
+
+	startGearbox(gb)
+
+	req, _ := http.NewRequest(MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "42")
+	response, err := makeRequest(req, gb)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if response.StatusCode != StatusServiceUnavailable {
+		t.Errorf("returned %d expected %d", response.StatusCode, StatusServiceUnavailable)
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "reported" {
+		t.Errorf("returned %s expected %s", body, "reported")
+	}
+
+	if response.Header.Get("X-Request-ID") != "42" || response.Header.Get("X-Partial") != "" {
+		t.Errorf("returned headers %v", response.Header)
+	}
+
+	if recovered != "unexpected" {
+		t.Errorf("recovered %v expected %s", recovered, "unexpected")
+	}
+
+	if !strings.Contains(string(stack), "TestRecoverHandler") {
+		t.Errorf("stack trace does not contain panicking handler: %s", stack)
+	}
+}
+
+// TestDefaultRecoverHandlers tests responses of default and development
+// recover handlers
+func TestDefaultRecoverHandlers(t *testing.T) {
+	testCases := []struct {
+		recoverHandler func(ctx Context, recovered interface{}, stack []byte)
+		statusCode     int
+		contentType    string
+		body           []string
+	}{
+		{statusCode: StatusInternalServerError, contentType: "text/plain; charset=utf-8",
+			body: []string{"Internal Server Error"}},
+		{recoverHandler: DefaultRecoverHandler, statusCode: StatusInternalServerError,
+			contentType: "text/plain; charset=utf-8", body: []string{"Internal Server Error"}},
+		{recoverHandler: DevelopmentRecoverHandler, statusCode: StatusInternalServerError,
+			contentType: "text/html; charset=utf-8",
+			body:        []string{"<html>", "GET /panic?q=1", "&lt;script&gt;", "TestDefaultRecoverHandlers"}},
+	}
+
+	for _, tc := range testCases {
+		gb := setupGearbox(&Settings{
+			AutoRecover:    true,
+			RecoverHandler: tc.recoverHandler,
+		})
+
+		gb.Get("/panic", func(ctx Context) {
+			panic("<script>")
+		})
+
+		startGearbox(gb)
+
+		req, _ := http.NewRequest(MethodGet, "/panic?q=1", nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("returned %d expected %d", response.StatusCode, tc.statusCode)
+		}
+
+		if contentType := response.Header.Get("Content-Type"); contentType != tc.contentType {
+			t.Errorf("returned content type %s expected %s", contentType, tc.contentType)
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		for _, part := range tc.body {
+			if !strings.Contains(string(body), part) {
+				t.Errorf("returned body %s does not contain %s", body, part)
+			}
+		}
+
+		if strings.Contains(string(body), "<script>") {
+			t.Errorf("returned body %s with unescaped panic value", body)
+		}
+	}
+}
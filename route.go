@@ -0,0 +1,60 @@
+package gearbox
+
+import (
+	"strings"
+)
+
+// Use attaches middlewares to the route, they are executed after global
+// middlewares and middlewares of route's groups
+func (r *Route) Use(middlewares ...handlerFunc) *Route {
+	r.middlewares = append(r.middlewares, middlewares...)
+	return r
+}
+
+// Name sets name of the route, it's accessible by handlers through
+// Context.RouteName
+func (r *Route) Name(name string) *Route {
+	r.name = name
+	return r
+}
+
+// Meta attaches a value to the route with the key, e.g. required role or
+// rate limit class, it's accessible by handlers through Context.RouteMeta
+func (r *Route) Meta(key string, value interface{}) *Route {
+	if r.meta == nil {
+		r.meta = make(map[string]interface{})
+	}
+	r.meta[key] = value
+	return r
+}
+
+// RouteError describes why a registered route is invalid
+type RouteError struct {
+	Method string
+	Path   string
+	Host   string
+	Err    error
+}
+
+// Error returns description of route's problem
+func (e *RouteError) Error() string {
+	route := e.Method + " " + e.Host + e.Path
+	return "invalid route " + route + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// RouteErrors holds problems of all invalid routes
+type RouteErrors []*RouteError
+
+// Error returns descriptions of routes' problems, one per line
+func (e RouteErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
@@ -0,0 +1,242 @@
+package gearbox
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRouteMiddlewaresAndMeta tests route's middlewares, name and metadata
+// are accessible while handling its requests
+func TestRouteMiddlewaresAndMeta(t *testing.T) {
+	gb := setupGearbox()
+
+	// authorize rejects requests of routes that require admin role
+	authorize := func(ctx Context) {
+		if role, _ := ctx.RouteMeta("role").(string); role == "admin" &&
+			ctx.Get("X-Role") != "admin" {
+			ctx.Status(StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+	gb.Use(authorize)
+
+	// describe answers with name and limit of matched route
+	describe := func(ctx Context) {
+		limit, _ := ctx.RouteMeta("limit").(int)
+		ctx.SendString(ctx.RouteName() + " " + strconv.Itoa(limit))
+	}
+
+	gb.Get("/users/:id", describe).Name("user.show").Meta("limit", 5)
+	gb.Get("/users", describe).Name("user.list")
+	gb.Delete("/users/:id", describe).Name("user.delete").Meta("role", "admin")
+	gb.Get("/:lang?/about", describe).Name("about")
+	gb.Get("/health", describe).Use(func(ctx Context) {
+		ctx.Set("X-Route", "health")
+		ctx.Next()
+	})
+
+	// Splits static node of /users
+	gb.Get("/use", describe).Name("use")
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		method     string
+		path       string
+		role       string
+		statusCode int
+		body       string
+		header     string
+	}{
+		{method: MethodGet, path: "/users/1", statusCode: StatusOK, body: "user.show 5"},
+		{method: MethodGet, path: "/users", statusCode: StatusOK, body: "user.list 0"},
+		{method: MethodGet, path: "/use", statusCode: StatusOK, body: "use 0"},
+		{method: MethodDelete, path: "/users/1", statusCode: StatusForbidden},
+		{method: MethodDelete, path: "/users/1", role: "admin", statusCode: StatusOK, body: "user.delete 0"},
+		{method: MethodGet, path: "/about", statusCode: StatusOK, body: "about 0"},
+		{method: MethodGet, path: "/en/about", statusCode: StatusOK, body: "about 0"},
+		{method: MethodGet, path: "/health", statusCode: StatusOK, body: " 0", header: "health"},
+	}
+
+	// Requesting twice to test cached matches
+	for i := 0; i < 2; i++ {
+		for _, tc := range testCases {
+			req, _ := http.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("X-Role", tc.role)
+			response, err := makeRequest(req, gb)
+			if err != nil {
+				t.Fatalf("%s(%s): %s", tc.method, tc.path, err.Error())
+			}
+
+			if response.StatusCode != tc.statusCode {
+				t.Errorf("%s(%s): returned %d expected %d", tc.method, tc.path, response.StatusCode, tc.statusCode)
+			}
+
+			body, _ := ioutil.ReadAll(response.Body)
+			if string(body) != tc.body {
+				t.Errorf("%s(%s): returned %s expected %s", tc.method, tc.path, body, tc.body)
+			}
+
+			if header := response.Header.Get("X-Route"); header != tc.header {
+				t.Errorf("%s(%s): returned header %s expected %s", tc.method, tc.path, header, tc.header)
+			}
+		}
+	}
+}
+
+// TestRouteMeta tests overriding route's metadata
+func TestRouteMeta(t *testing.T) {
+	gb := setupGearbox()
+	route := gb.Get("/", emptyHandler).Meta("key", 1).Meta("key", 2).Name("a").Name("b")
+
+	if route.meta["key"] != 2 {
+		t.Errorf("meta key is %v expected %v", route.meta["key"], 2)
+	}
+
+	if route.name != "b" {
+		t.Errorf("name is %s expected %s", route.name, "b")
+	}
+}
+
+// TestBuild tests collecting errors of all invalid routes
+func TestBuild(t *testing.T) {
+	gb := setupGearbox()
+	gb.Get("/users/:id", emptyHandler)
+	gb.Get("/users/:name", emptyHandler)
+	gb.Get("/files/*filepath/view", emptyHandler)
+	gb.Get("orders", emptyHandler)
+	gb.Get("/orders/:id<[0-9+>", emptyHandler)
+	gb.Post("/users", emptyHandler).Name("users")
+	gb.Put("/users", emptyHandler).Name("users")
+	gb.Put("/accounts", emptyHandler).Name("users")
+	gb.Host("..example.com", []*Route{gb.Get("/", emptyHandler)})
+	gb.Get("/health", emptyHandler)
+
+	err := gb.Build()
+	routeErrors, ok := err.(RouteErrors)
+	if !ok {
+		t.Fatalf("returned error %v expected RouteErrors", err)
+	}
+
+	expected := []RouteError{
+		{Method: MethodGet, Path: "/users/:name"},
+		{Method: MethodGet, Path: "/files/*filepath/view"},
+		{Method: MethodGet, Path: "orders"},
+		{Method: MethodGet, Path: "/orders/:id<[0-9+>"},
+		{Method: MethodPut, Path: "/accounts"},
+		{Method: MethodGet, Path: "/", Host: "..example.com"},
+	}
+
+	if len(routeErrors) != len(expected) {
+		t.Fatalf("returned %d errors expected %d: %v", len(routeErrors), len(expected), err)
+	}
+
+	for i, routeError := range routeErrors {
+		if routeError.Method != expected[i].Method || routeError.Path != expected[i].Path ||
+			routeError.Host != expected[i].Host || routeError.Err == nil {
+			t.Errorf("returned error %+v expected %+v", routeError, expected[i])
+		}
+	}
+
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != len(expected) {
+		t.Errorf("returned %d error lines expected %d", len(lines), len(expected))
+	}
+
+	// Errors of all invalid routes are returned when building again
+	gb.Get("/status", emptyHandler)
+	if err := gb.Build(); err == nil || len(err.(RouteErrors)) != len(expected) {
+		t.Errorf("building again: returned error %v expected %d route errors", err, len(expected))
+	}
+
+	gb.Get("/status", emptyHandler)
+	if err := gb.Build(); err == nil || len(err.(RouteErrors)) != len(expected)+1 {
+		t.Errorf("building again: returned error %v expected %d route errors", err, len(expected)+1)
+	}
+
+	// Valid routes are served
+	gb.httpServer = gb.newHTTPServer()
+	for _, path := range []string{"/health", "/status"} {
+		req, _ := http.NewRequest(MethodGet, path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+
+		if response.StatusCode != StatusOK {
+			t.Errorf("%s: returned %d expected %d", path, response.StatusCode, StatusOK)
+		}
+	}
+
+	// Service is not started with invalid routes
+	if err := gb.Start(":3060"); err == nil {
+		t.Errorf("starting with invalid routes: expected error")
+	}
+}
+
+// TestBuildAgain tests adding routes registered after previous build
+func TestBuildAgain(t *testing.T) {
+	gb := setupGearbox()
+	gb.Get("/users/:id", func(ctx Context) {
+		ctx.SendString("user " + ctx.Param("id"))
+	})
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		path string
+		body string
+	}{
+		{path: "/users/me", body: "user me"},
+		{path: "/users/me", body: "me"},
+		{path: "/users/42", body: "user 42"},
+	}
+
+	for i, tc := range testCases {
+		// Route shadowing a cached match is added after the first request
+		if i == 1 {
+			gb.Get("/users/me", func(ctx Context) {
+				ctx.SendString("me")
+			})
+			if err := gb.Build(); err != nil {
+				t.Fatalf("building again: unexpected error %v", err)
+			}
+		}
+
+		req, _ := http.NewRequest(MethodGet, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s", err.Error())
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != tc.body {
+			t.Errorf("%s: returned %s expected %s", tc.path, body, tc.body)
+		}
+	}
+}
+
+// TestBuildAfterStart tests building routes while serving requests
+func TestBuildAfterStart(t *testing.T) {
+	gb := New().(*gearbox)
+	gb.Get("/health", emptyHandler)
+
+	errs := make(chan error, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		errs <- gb.Build()
+		gb.Stop()
+	}()
+
+	if err := gb.Start(":3070"); err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if err := <-errs; err == nil {
+		t.Errorf("building after starting: expected error")
+	}
+}
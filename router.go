@@ -1,7 +1,9 @@
 package gearbox
 
 import (
-	"log"
+	"errors"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -13,18 +15,24 @@ var (
 )
 
 type router struct {
-	trees    map[string]*node
-	cache    map[string]*matchResult
-	cacheLen int
-	mutex    sync.RWMutex
-	notFound handlersChain
-	settings *Settings
-	pool     sync.Pool
+	trees     map[string]*node
+	hosts     []*hostTrees
+	cache     *routeCache
+	notFound  handlersChain
+	settings  *Settings
+	pool      sync.Pool
+	maxParams int
+
+	// handlers chains of requests that do not match any route
+	notFoundChain         handlersChain
+	methodNotAllowedChain handlersChain
+	optionsChain          handlersChain
 }
 
 type matchResult struct {
 	handlers handlersChain
-	params   map[string]string
+	route    *Route
+	params   []pathParam
 }
 
 // acquireCtx returns instance of context after initializing it
@@ -33,8 +41,14 @@ func (r *router) acquireCtx(fctx *fasthttp.RequestCtx) *context {
 
 	// Initialize
 	ctx.index = 0
-	ctx.paramValues = make(map[string]string)
 	ctx.requestCtx = fctx
+	ctx.settings = r.settings
+
+	// Params storage is reused between requests and it's only allocated
+	// if it can not hold params of the longest registered route
+	if cap(ctx.params) < r.maxParams {
+		ctx.params = make([]pathParam, 0, r.maxParams)
+	}
 
 	return ctx
 }
@@ -42,22 +56,42 @@ func (r *router) acquireCtx(fctx *fasthttp.RequestCtx) *context {
 // releaseCtx frees context
 func (r *router) releaseCtx(ctx *context) {
 	ctx.handlers = nil
-	ctx.paramValues = nil
+	ctx.route = nil
+	ctx.params = ctx.params[:0]
 	ctx.requestCtx = nil
 	r.pool.Put(ctx)
 }
 
 // handle registers handlers for provided method and path to be used
 // in routing incoming requests
-func (r *router) handle(method, path string, handlers handlersChain) {
+func (r *router) handle(method, path string, handlers handlersChain) error {
+	_, err := r.handleHost("", method, path, handlers)
+	return err
+}
+
+// handleRoute registers handlers for provided route and keeps the route
+// to be accessible through context of its requests
+func (r *router) handleRoute(route *Route, handlers handlersChain) error {
+	nodes, err := r.handleHost(route.Host, route.Method, route.Path, handlers)
+	for _, n := range nodes {
+		n.route = route
+	}
+	return err
+}
+
+// handleHost registers handlers for provided method and path to be used
+// in routing incoming requests with host matching the host pattern,
+// empty host pattern registers them for requests of any host.
+// It returns the nodes holding the handlers
+func (r *router) handleHost(host, method, path string, handlers handlersChain) ([]*node, error) {
 	if path == "" {
-		panic("path is empty")
+		return nil, errors.New("path is empty")
 	} else if method == "" {
-		panic("method is empty")
+		return nil, errors.New("method is empty")
 	} else if path[0] != '/' {
-		panic("path must begin with '/' in path '" + path + "'")
+		return nil, errors.New("path must begin with '/' in path '" + path + "'")
 	} else if len(handlers) == 0 {
-		panic("no handlers provided with path '" + path + "'")
+		return nil, errors.New("no handlers provided with path '" + path + "'")
 	}
 
 	// initialize tree if it's empty
@@ -65,54 +99,175 @@ func (r *router) handle(method, path string, handlers handlersChain) {
 		r.trees = make(map[string]*node)
 	}
 
+	paramsCount, err := countParams(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trees := r.trees
+	if host != "" {
+		hostRoutes, err := r.hostTrees(host)
+		if err != nil {
+			return nil, err
+		}
+		trees = hostRoutes.trees
+		paramsCount += hostRoutes.params
+	}
+
+	if r.settings.MaxRouteParams > 0 && paramsCount > r.settings.MaxRouteParams {
+		return nil, errors.New("number of parameters in path '" + path + "' exceeds " +
+			"maximum number of route params " + strconv.Itoa(r.settings.MaxRouteParams))
+	}
+
+	// Static parts are matched case-insensitively against lower case paths
+	if r.settings.CaseInSensitive {
+		path = lowerStaticSegments(path)
+	}
+
 	// get root of method if it's existing, otherwise creates it
-	root := r.trees[method]
+	root := trees[method]
 	if root == nil {
 		root = createRootNode()
-		r.trees[method] = root
+		trees[method] = root
+	}
+
+	nodes, err := root.addRoute(path, handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	if paramsCount > r.maxParams {
+		r.maxParams = paramsCount
+	}
+
+	return nodes, nil
+}
+
+// hostTrees returns trees of host pattern if it's existing, otherwise
+// creates them keeping patterns without params before wildcard ones
+func (r *router) hostTrees(pattern string) (*hostTrees, error) {
+	h, err := newHostTrees(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hostRoutes := range r.hosts {
+		if hostRoutes.pattern == h.pattern {
+			return hostRoutes, nil
+		}
+	}
+
+	index := len(r.hosts)
+	if h.params == 0 {
+		for i, hostRoutes := range r.hosts {
+			if hostRoutes.params > 0 {
+				index = i
+				break
+			}
+		}
 	}
 
-	root.addRoute(path, handlers)
+	r.hosts = append(r.hosts, nil)
+	copy(r.hosts[index+1:], r.hosts[index:])
+	r.hosts[index] = h
+	return h, nil
+}
+
+// matchHosts appends trees of host patterns that match host to hosts in
+// the order of patterns, exact patterns are before ones with params
+func (r *router) matchHosts(host string, hosts []*hostTrees) []*hostTrees {
+	for _, hostRoutes := range r.hosts {
+		if hostRoutes.match(host, nil) {
+			hosts = append(hosts, hostRoutes)
+		}
+	}
+	return hosts
+}
+
+// matchRequest returns the node that holds handlers registered with method
+// and path in trees of the matched hosts in order, and falls back to routes
+// registered for any host
+func (r *router) matchRequest(method, host, path string, hosts []*hostTrees, ctx *context) *node {
+	for _, hostRoutes := range hosts {
+		if root := hostRoutes.trees[method]; root != nil {
+			if matched := r.matchRoute(root, path, ctx); matched != nil {
+				hostRoutes.match(host, ctx)
+				return matched
+			}
+		}
+	}
+
+	if root := r.trees[method]; root != nil {
+		if matched := r.matchRoute(root, path, ctx); matched != nil {
+			return matched
+		}
+	}
+
+	// HEAD requests are handled by GET routes if there is no HEAD route
+	if r.autoHead(method) {
+		return r.matchRequest(MethodGet, host, path, hosts, ctx)
+	}
+	return nil
+}
+
+// autoHead checks if requests of method can be handled by GET routes
+func (r *router) autoHead(method string) bool {
+	return method == MethodHead && !r.settings.DisableAutoHead
+}
+
+// matchRoute returns the node that holds handlers registered with the given path
+// in the tree and matches static parts case-insensitively if it's enabled
+func (r *router) matchRoute(root *node, path string, ctx *context) *node {
+	if r.settings.CaseInSensitive {
+		return root.matchRouteFold(path, ctx)
+	}
+	return root.matchRoute(path, ctx)
 }
 
 // allowed checks if provided path can be routed in another method(s)
-func (r *router) allowed(reqMethod, path string, ctx *context) string {
-	var allow string
+func (r *router) allowed(reqMethod, path string, hosts []*hostTrees, ctx *context) string {
+	var methods []string
 
 	pathLen := len(path)
 
 	// handle * and /* requests
-	if (pathLen == 1 && path[0] == '*') || (pathLen > 1 && path[1] == '*') {
-		for method := range r.trees {
-			if method == MethodOptions {
+	all := (pathLen == 1 && path[0] == '*') || (pathLen > 1 && path[1] == '*')
+
+	trees := []map[string]*node{r.trees}
+	for _, hostRoutes := range hosts {
+		trees = append(trees, hostRoutes.trees)
+	}
+
+	for _, methodTrees := range trees {
+		for method, tree := range methodTrees {
+			if method == MethodOptions || containsString(methods, method) {
 				continue
 			}
 
-			if allow != "" {
-				allow += ", " + method
-			} else {
-				allow = method
+			if !all {
+				if method == reqMethod || (method == MethodGet && r.autoHead(reqMethod)) {
+					continue
+				}
+
+				matched := r.matchRoute(tree, path, ctx)
+				ctx.params = ctx.params[:0]
+				if matched == nil {
+					continue
+				}
 			}
-		}
-		return allow
-	}
 
-	for method, tree := range r.trees {
-		if method == reqMethod || method == MethodOptions {
-			continue
-		}
+			methods = append(methods, method)
 
-		handlers := tree.matchRoute(path, ctx)
-		if handlers != nil {
-			if allow != "" {
-				allow += ", " + method
-			} else {
-				allow = method
+			// HEAD is allowed wherever GET is allowed
+			if method == MethodGet && r.autoHead(MethodHead) &&
+				!containsString(methods, MethodHead) {
+				methods = append(methods, MethodHead)
 			}
 		}
 	}
 
-	if len(allow) > 0 {
+	allow := strings.Join(methods, ", ")
+	if !all && len(allow) > 0 {
 		allow += ", " + MethodOptions
 	}
 	return allow
@@ -120,92 +275,236 @@ func (r *router) allowed(reqMethod, path string, ctx *context) string {
 
 // Handler handles all incoming requests
 func (r *router) Handler(fctx *fasthttp.RequestCtx) {
+	if r.settings.MaxRequestURLLength > 0 &&
+		len(fctx.Request.Header.RequestURI()) > r.settings.MaxRequestURLLength {
+		writeError(fctx, r.settings, fasthttp.StatusRequestURITooLong, "")
+		return
+	}
+
 	context := r.acquireCtx(fctx)
 	defer r.releaseCtx(context)
 
 	if r.settings.AutoRecover {
 		defer func(fctx *fasthttp.RequestCtx) {
 			if rcv := recover(); rcv != nil {
-				log.Printf("recovered from error: %v", rcv)
-				fctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError),
-					fasthttp.StatusInternalServerError)
+				// Partial response of handlers is dropped
+				fctx.Response.Reset()
+
+				recoverHandler := r.settings.RecoverHandler
+				if recoverHandler == nil {
+					recoverHandler = DefaultRecoverHandler
+				}
+				recoverHandler(context, rcv, debug.Stack())
 			}
 		}(fctx)
 	}
 
 	path := GetString(fctx.URI().PathOriginal())
-
-	if r.settings.CaseInSensitive {
-		path = strings.ToLower(path)
-	}
-
 	method := GetString(fctx.Method())
 
-	var cacheKey string
-	useCache := !r.settings.DisableCaching &&
-		(method == MethodGet || method == MethodPost)
-	if useCache {
-		cacheKey = path + method
-		r.mutex.RLock()
-		cacheResult, ok := r.cache[cacheKey]
-
-		if ok {
+	// Host is only looked up if there are routes registered for host patterns
+	var host string
+	if len(r.hosts) > 0 {
+		host = hostname(GetString(fctx.Host()))
+		context.hosts = r.matchHosts(host, context.hosts[:0])
+	}
+	hosts := context.hosts
+
+	if r.cache != nil {
+		// Cache key is built in context's buffer to avoid allocating it
+		// for every request, host is part of the key only if it matches
+		// a host pattern since other hosts share the same routes
+		context.cacheKey = append(context.cacheKey[:0], method...)
+		if len(hosts) > 0 {
+			context.cacheKey = append(append(context.cacheKey, ' '), host...)
+		}
+		context.cacheKey = append(context.cacheKey, path...)
+		if cacheResult := r.cache.get(GetString(context.cacheKey)); cacheResult != nil {
 			context.handlers = cacheResult.handlers
-			context.paramValues = cacheResult.params
-			r.mutex.RUnlock()
+			context.route = cacheResult.route
+			context.params = append(context.params, cacheResult.params...)
+			if r.settings.UnescapePathValues {
+				context.unescapeParams()
+			}
 			context.handlers[0](context)
 			return
 		}
-		r.mutex.RUnlock()
 	}
 
-	if root := r.trees[method]; root != nil {
-		if handlers := root.matchRoute(path, context); handlers != nil {
-			context.handlers = handlers
-			context.handlers[0](context)
-
-			if useCache {
-				r.mutex.Lock()
+	if matched := r.matchRequest(method, host, path, hosts, context); matched != nil {
+		if r.settings.RedirectTrailingSlash && matched.nType != catchAll &&
+			len(path) > 1 && (path[len(path)-1] == '/') != matched.trailingSlash {
+			if matched.trailingSlash {
+				r.redirect(fctx, method, path+"/")
+			} else {
+				r.redirect(fctx, method, path[:len(path)-1])
+			}
+			return
+		}
 
-				if r.cacheLen == r.settings.CacheSize {
-					r.cache = make(map[string]*matchResult)
-					r.cacheLen = 0
-				}
-				r.cache[cacheKey] = &matchResult{
-					handlers: handlers,
-					params:   context.paramValues,
-				}
-				r.cacheLen++
-				r.mutex.Unlock()
+		if r.cache != nil {
+			// Cache keeps its own copy of params to not be affected
+			// by handlers changing request's params or by reusing
+			// request's buffer that values are pointing to
+			params := make([]pathParam, len(context.params))
+			for i, p := range context.params {
+				value := string([]byte(p.raw))
+				params[i] = pathParam{key: p.key, value: value, raw: value}
 			}
+
+			r.cache.set(string(context.cacheKey), &matchResult{
+				handlers: matched.handlers,
+				route:    matched.route,
+				params:   params,
+			})
+		}
+
+		if r.settings.UnescapePathValues {
+			context.unescapeParams()
+		}
+
+		context.handlers = matched.handlers
+		context.route = matched.route
+		context.handlers[0](context)
+		return
+	}
+
+	if r.settings.RedirectFixedPath && method != MethodConnect {
+		if fixedPath, ok := r.fixedPath(method, path, hosts, context); ok && fixedPath != path {
+			r.redirect(fctx, method, fixedPath)
 			return
 		}
 	}
 
+	// Allow header is set before executing middlewares to be accessible
+	// by them, e.g. CORS middleware
 	if method == MethodOptions && r.settings.HandleOPTIONS {
-		if allow := r.allowed(method, path, context); len(allow) > 0 {
+		if allow := r.allowed(method, path, hosts, context); len(allow) > 0 {
 			fctx.Response.Header.Set("Allow", allow)
+			context.run(r.optionsChain, handleOptions)
 			return
 		}
 	} else if r.settings.HandleMethodNotAllowed {
-		if allow := r.allowed(method, path, context); len(allow) > 0 {
+		if allow := r.allowed(method, path, hosts, context); len(allow) > 0 {
 			fctx.Response.Header.Set("Allow", allow)
-			fctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
-			fctx.SetContentTypeBytes(defaultContentType)
-			fctx.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusMethodNotAllowed))
+			context.run(r.methodNotAllowedChain, handleMethodNotAllowed)
 			return
 		}
 	}
 
-	// Custom Not Found (404) handlers
-	if r.notFound != nil {
-		r.notFound[0](context)
+	// Custom Not Found (404) handlers are used if router is not set up yet
+	if r.notFoundChain == nil {
+		context.run(r.notFound, handleNotFound)
 		return
 	}
+	context.run(r.notFoundChain, handleNotFound)
+}
+
+// setupFallbacks builds handlers chains of requests that do not match any
+// route, middlewares are executed before answering them
+func (r *router) setupFallbacks(middlewares handlersChain) {
+	notFound := r.notFound
+	if len(notFound) == 0 {
+		notFound = handlersChain{handleNotFound}
+	}
+
+	r.notFoundChain = append(append(handlersChain{}, middlewares...), notFound...)
+	r.methodNotAllowedChain = append(append(handlersChain{}, middlewares...), handleMethodNotAllowed)
+	r.optionsChain = append(append(handlersChain{}, middlewares...), handleOptions)
+}
+
+// handleNotFound answers with default Not Found (404) response, headers set
+// by middlewares are kept
+func handleNotFound(ctx Context) {
+	ctx.(*context).sendError(fasthttp.StatusNotFound, "")
+}
+
+// handleMethodNotAllowed answers with default Method Not Allowed (405)
+// response, Allow header is already set
+func handleMethodNotAllowed(ctx Context) {
+	ctx.(*context).sendError(fasthttp.StatusMethodNotAllowed, "")
+}
+
+// handleOptions answers OPTIONS requests with empty response, Allow header
+// is already set
+func handleOptions(ctx Context) {}
+
+// fixedPath returns path of the route that matches the given path after
+// cleaning it and correcting its case if routing is case sensitive, trailing
+// slash of the route is used if trailing slashes are redirected
+func (r *router) fixedPath(method, path string, hosts []*hostTrees, ctx *context) (string, bool) {
+	defer func() {
+		ctx.params = ctx.params[:0]
+	}()
+
+	// Routes of matched hosts are tried first, and GET routes are tried after
+	// HEAD routes for HEAD requests
+	var roots []*node
+	methods := []string{method}
+	if r.autoHead(method) {
+		methods = append(methods, MethodGet)
+	}
+	for _, m := range methods {
+		for _, hostRoutes := range hosts {
+			roots = append(roots, hostRoutes.trees[m])
+		}
+		roots = append(roots, r.trees[m])
+	}
+
+	cleanedPath := cleanPath(path)
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+
+		if cleanedPath != path {
+			if matched := r.matchRoute(root, cleanedPath, ctx); matched != nil {
+				// Trailing slash of route is used to redirect only once
+				// to the canonical path
+				if r.settings.RedirectTrailingSlash && matched.nType != catchAll &&
+					len(cleanedPath) > 1 && (cleanedPath[len(cleanedPath)-1] == '/') != matched.trailingSlash {
+					if matched.trailingSlash {
+						return cleanedPath + "/", true
+					}
+					return cleanedPath[:len(cleanedPath)-1], true
+				}
+				return cleanedPath, true
+			}
+		}
+
+		if !r.settings.CaseInSensitive {
+			if fixedPath, ok := root.findCaseInsensitivePath(cleanedPath); ok {
+				return fixedPath, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// redirect answers with a redirect to the location keeping query string,
+// it uses 301 for GET requests and 308 for other methods to keep them
+// and their bodies unchanged
+func (r *router) redirect(fctx *fasthttp.RequestCtx, method, location string) {
+	status := fasthttp.StatusPermanentRedirect
+	if method == MethodGet || method == MethodHead {
+		status = fasthttp.StatusMovedPermanently
+	}
+
+	if queryString := fctx.URI().QueryString(); len(queryString) > 0 {
+		location += "?" + GetString(queryString)
+	}
+
+	fctx.Response.Header.Set("Location", location)
+	fctx.SetStatusCode(status)
+}
 
-	// Default Not Found response
-	fctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound),
-		fasthttp.StatusNotFound)
+// CacheStats returns counters of routing cache
+func (r *router) CacheStats() CacheStats {
+	if r.cache == nil {
+		return CacheStats{}
+	}
+	return r.cache.stats()
 }
 
 // SetNotFound appends handlers to custom not found (404) handlers
@@ -0,0 +1,33 @@
+//go:build !race
+// +build !race
+
+package gearbox
+
+import "testing"
+
+// Race detector drops pooled contexts randomly, so allocations are only
+// tested without it
+
+// TestHandlerAllocs tests that matching routes with params does not allocate
+func TestHandlerAllocs(t *testing.T) {
+	settings := []*Settings{
+		{DisableCaching: true},
+		{},
+	}
+
+	for _, s := range settings {
+		r := newBenchmarkRouter(s)
+		fctx := newBenchmarkRequest(MethodGet, "/users/42/posts/7")
+
+		// Warm up pool and cache
+		r.Handler(fctx)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			r.Handler(fctx)
+		})
+
+		if allocs != 0 {
+			t.Errorf("handler with caching disabled %t allocates %v times", s.DisableCaching, allocs)
+		}
+	}
+}
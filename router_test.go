@@ -1,8 +1,13 @@
 package gearbox
 
 import (
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"sync"
 	"testing"
+
+	"github.com/valyala/fasthttp"
 )
 
 func TestHandle(t *testing.T) {
@@ -23,7 +28,7 @@ func TestHandle(t *testing.T) {
 
 	router := &router{
 		settings: &Settings{},
-		cache:    make(map[string]*matchResult),
+		cache:    newRouteCache(defaultCacheSize),
 		pool: sync.Pool{
 			New: func() interface{} {
 				return new(context)
@@ -32,16 +37,14 @@ func TestHandle(t *testing.T) {
 	}
 
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			router.handle(route.method, route.path, route.handlers)
-		})
+		err := router.handle(route.method, route.path, route.handlers)
 
 		if route.conflict {
-			if recv == nil {
-				t.Errorf("no panic for conflicting route '%s'", route.path)
+			if err == nil {
+				t.Errorf("no error for conflicting route '%s'", route.path)
 			}
-		} else if recv != nil {
-			t.Errorf("unexpected panic for route '%s': %v", route.path, recv)
+		} else if err != nil {
+			t.Errorf("unexpected error for route '%s': %v", route.path, err)
 		}
 	}
 
@@ -59,7 +62,7 @@ func TestHandler(t *testing.T) {
 
 	router := &router{
 		settings: &Settings{},
-		cache:    make(map[string]*matchResult),
+		cache:    newRouteCache(defaultCacheSize),
 		pool: sync.Pool{
 			New: func() interface{} {
 				return new(context)
@@ -72,3 +75,400 @@ func TestHandler(t *testing.T) {
 	}
 
 }
+
+// TestHandlerCache tests matching requests from cache for all methods
+// without sharing params between requests
+func TestHandlerCache(t *testing.T) {
+	gb := setupGearbox()
+
+	// paramHandler answers with id param then changes it
+	paramHandler := func(ctx Context) {
+		ctx.SendString(ctx.Param("id"))
+		ctx.(*context).params[0].value = "changed"
+	}
+
+	gb.Get("/users/:id", paramHandler)
+	gb.Put("/users/:id", paramHandler)
+	gb.Delete("/users/:id", paramHandler)
+
+	startGearbox(gb)
+
+	methods := []string{MethodGet, MethodPut, MethodDelete}
+	for i := 0; i < 2; i++ {
+		for _, method := range methods {
+			req, _ := http.NewRequest(method, "/users/42", nil)
+			response, err := makeRequest(req, gb)
+			if err != nil {
+				t.Fatalf("%s(%s): %s", method, "/users/42", err.Error())
+			}
+
+			body, _ := ioutil.ReadAll(response.Body)
+			if string(body) != "42" {
+				t.Fatalf("%s(%s): returned %s expected %s", method, "/users/42", body, "42")
+			}
+		}
+	}
+
+	stats := gb.CacheStats()
+	if stats.Hits != 3 || stats.Misses != 3 || stats.Entries != 3 {
+		t.Errorf("unexpected cache stats %+v", stats)
+	}
+}
+
+// newBenchmarkRouter returns a router with routes used in benchmarks
+func newBenchmarkRouter(settings *Settings) *router {
+	r := &router{
+		settings: settings,
+		pool: sync.Pool{
+			New: func() interface{} {
+				return new(context)
+			},
+		},
+	}
+
+	if !settings.DisableCaching {
+		r.cache = newRouteCache(settings.CacheSize)
+	}
+
+	r.handle(MethodGet, "/users/:id/posts/:post", fakeHandlersChain)
+	r.handle(MethodGet, "/files/*filepath", fakeHandlersChain)
+	return r
+}
+
+// newBenchmarkRequest returns a request context with method and uri
+func newBenchmarkRequest(method, uri string) *fasthttp.RequestCtx {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI(uri)
+	return fctx
+}
+
+// BenchmarkHandlerParams benchmarks matching a route with params
+func BenchmarkHandlerParams(b *testing.B) {
+	r := newBenchmarkRouter(&Settings{DisableCaching: true})
+	fctx := newBenchmarkRequest(MethodGet, "/users/42/posts/7")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Handler(fctx)
+	}
+}
+
+// BenchmarkHandlerParamsCached benchmarks matching a cached route with params
+func BenchmarkHandlerParamsCached(b *testing.B) {
+	r := newBenchmarkRouter(&Settings{})
+	fctx := newBenchmarkRequest(MethodGet, "/users/42/posts/7")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Handler(fctx)
+	}
+}
+
+// BenchmarkHandlerCatchAll benchmarks matching a catch all route
+func BenchmarkHandlerCatchAll(b *testing.B) {
+	r := newBenchmarkRouter(&Settings{DisableCaching: true})
+	fctx := newBenchmarkRequest(MethodGet, "/files/css/themes/dark.css")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Handler(fctx)
+	}
+}
+
+// TestHandleMaxRouteParams tests registering routes with params count
+// exceeding the limit
+func TestHandleMaxRouteParams(t *testing.T) {
+	routes := []struct {
+		path     string
+		conflict bool
+	}{
+		{path: "/users/:id", conflict: false},
+		{path: "/users/:id/posts/:post", conflict: false},
+		{path: "/users/:id/posts/:post/comments/:comment", conflict: true},
+		{path: "/range/:from-:to/:unit", conflict: true},
+		{path: "/files/:bucket/*filepath", conflict: false},
+		{path: "/files/:bucket/:folder/*filepath", conflict: true},
+		{path: "/archive/:year?/:month?/:day?", conflict: true},
+	}
+
+	router := &router{
+		settings: &Settings{MaxRouteParams: 2},
+	}
+
+	for _, route := range routes {
+		err := router.handle(MethodGet, route.path, fakeHandlersChain)
+
+		if route.conflict {
+			if err == nil {
+				t.Errorf("no error for route '%s' exceeding params limit", route.path)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error for route '%s': %v", route.path, err)
+		}
+	}
+}
+
+// TestHandlerMaxRequestURLLength tests answering requests with long url
+func TestHandlerMaxRequestURLLength(t *testing.T) {
+	gb := setupGearbox(&Settings{
+		MaxRequestURLLength: 20,
+	})
+
+	gb.Get("/users/:id", emptyHandler)
+
+	startGearbox(gb)
+
+	testCases := []struct {
+		path       string
+		statusCode int
+	}{
+		{path: "/users/42", statusCode: StatusOK},
+		{path: "/users/42?name=test", statusCode: StatusOK},
+		{path: "/users/42?name=testing", statusCode: StatusRequestURITooLong},
+		{path: "/users/4242424242424242", statusCode: StatusRequestURITooLong},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(MethodGet, tc.path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", MethodGet, tc.path, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s(%s): returned %d expected %d", MethodGet, tc.path, response.StatusCode, tc.statusCode)
+		}
+	}
+}
+
+// TestHandlerMaxRequestURLLengthSettings tests default limit of url length
+// and raising it
+func TestHandlerMaxRequestURLLengthSettings(t *testing.T) {
+	longPath := "/users/" + strings.Repeat("4", 3000)
+
+	testCases := []struct {
+		settings   *Settings
+		statusCode int
+	}{
+		{settings: &Settings{}, statusCode: StatusRequestURITooLong},
+		{settings: &Settings{MaxRequestURLLength: 8192}, statusCode: StatusOK},
+	}
+
+	for _, tc := range testCases {
+		gb := New(tc.settings).(*gearbox)
+		gb.Get("/users/:id", emptyHandler)
+		startGearbox(gb)
+
+		req, _ := http.NewRequest(MethodGet, longPath, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s: %s", MethodGet, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("limit %d: returned %d expected %d", tc.settings.MaxRequestURLLength,
+				response.StatusCode, tc.statusCode)
+		}
+	}
+}
+
+// TestHandlerCaseInSensitive tests that params keep their original case
+// when routing is case insensitive
+func TestHandlerCaseInSensitive(t *testing.T) {
+	gb := setupGearbox(&Settings{
+		CaseInSensitive: true,
+	})
+
+	gb.Get("/Users/:userID", func(ctx Context) {
+		ctx.SendString(ctx.Param("userID"))
+	})
+
+	startGearbox(gb)
+
+	// Requesting twice to test cached matches
+	paths := []string{"/users/aZ09Bx", "/USERS/aZ09Bx", "/users/aZ09Bx", "/USERS/aZ09Bx"}
+	for _, path := range paths {
+		req, _ := http.NewRequest(MethodGet, path, nil)
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", MethodGet, path, err.Error())
+		}
+
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != "aZ09Bx" {
+			t.Errorf("%s(%s): returned %s expected %s", MethodGet, path, body, "aZ09Bx")
+		}
+	}
+}
+
+// TestHandlerUnescapePathValues tests decoding percent-encoded params with
+// and without cached matches
+func TestHandlerUnescapePathValues(t *testing.T) {
+	testCases := []struct {
+		unescape bool
+		path     string
+		body     string
+	}{
+		{unescape: true, path: "/users/john%20doe", body: "john doe|john%20doe"},
+		{unescape: true, path: "/users/a%2Fb", body: "a/b|a%2Fb"},
+		{unescape: true, path: "/files/my%20docs/a%20b.txt", body: "my docs/a b.txt|my%20docs/a%20b.txt"},
+		{unescape: false, path: "/users/john%20doe", body: "john%20doe|john%20doe"},
+		{unescape: false, path: "/files/my%20docs/a%20b.txt", body: "my%20docs/a%20b.txt|my%20docs/a%20b.txt"},
+	}
+
+	for _, tc := range testCases {
+		gb := setupGearbox(&Settings{
+			UnescapePathValues: tc.unescape,
+		})
+
+		gb.Get("/users/:name", func(ctx Context) {
+			ctx.SendString(ctx.Param("name") + "|" + ctx.RawParam("name"))
+		})
+		gb.Get("/files/*filepath", func(ctx Context) {
+			ctx.SendString(ctx.Param("filepath") + "|" + ctx.RawParam("filepath"))
+		})
+
+		startGearbox(gb)
+
+		// Second request is matched from cache
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest(MethodGet, tc.path, nil)
+			response, err := makeRequest(req, gb)
+			if err != nil {
+				t.Fatalf("%s(%s): %s", MethodGet, tc.path, err.Error())
+			}
+
+			body, _ := ioutil.ReadAll(response.Body)
+			if string(body) != tc.body {
+				t.Errorf("%s(%s): returned %s expected %s", MethodGet, tc.path, body, tc.body)
+			}
+		}
+	}
+}
+
+// TestHandlerRedirect tests redirecting requests to canonical paths
+func TestHandlerRedirect(t *testing.T) {
+	testCases := []struct {
+		settings   *Settings
+		method     string
+		path       string
+		statusCode int
+		location   string
+	}{
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/users/", statusCode: StatusMovedPermanently, location: "/users"},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/users/?page=2", statusCode: StatusMovedPermanently, location: "/users?page=2"},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodPost, path: "/users/", statusCode: StatusPermanentRedirect, location: "/users"},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/docs", statusCode: StatusMovedPermanently, location: "/docs/"},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/docs/", statusCode: StatusOK},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/files/a/", statusCode: StatusOK},
+		{settings: &Settings{RedirectTrailingSlash: true}, method: MethodGet, path: "/", statusCode: StatusOK},
+		{settings: &Settings{}, method: MethodGet, path: "/users/", statusCode: StatusOK},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodGet, path: "/a//../users", statusCode: StatusMovedPermanently, location: "/users"},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodPost, path: "/./users", statusCode: StatusPermanentRedirect, location: "/users"},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodGet, path: "/USERS", statusCode: StatusMovedPermanently, location: "/users"},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodGet, path: "/Files/ReadMe", statusCode: StatusMovedPermanently, location: "/files/ReadMe"},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodGet, path: "/unknown", statusCode: StatusNotFound},
+		{settings: &Settings{RedirectFixedPath: true, CaseInSensitive: true}, method: MethodGet, path: "/USERS", statusCode: StatusOK},
+		{settings: &Settings{RedirectFixedPath: true, RedirectTrailingSlash: true}, method: MethodGet, path: "/x//a/", statusCode: StatusMovedPermanently, location: "/x/a"},
+		{settings: &Settings{RedirectFixedPath: true, RedirectTrailingSlash: true}, method: MethodGet, path: "/./docs", statusCode: StatusMovedPermanently, location: "/docs/"},
+		{settings: &Settings{RedirectFixedPath: true}, method: MethodGet, path: "/x//a/", statusCode: StatusMovedPermanently, location: "/x/a/"},
+		{settings: &Settings{}, method: MethodGet, path: "/USERS", statusCode: StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		gb := setupGearbox(tc.settings)
+
+		gb.Get("/", emptyHandler)
+		gb.Get("/users", emptyHandler)
+		gb.Post("/users", emptyHandler)
+		gb.Get("/docs/", emptyHandler)
+		gb.Get("/dogs", emptyHandler)
+		gb.Get("/files/*filepath", emptyHandler)
+		gb.Get("/x/:y", emptyHandler)
+
+		startGearbox(gb)
+
+		// Opaque keeps path as it is without cleaning it
+		req, _ := http.NewRequest(tc.method, "http://localhost"+tc.path, nil)
+		req.URL.Opaque = req.URL.EscapedPath()
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s(%s): %s", tc.method, tc.path, err.Error())
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s(%s): returned %d expected %d", tc.method, tc.path, response.StatusCode, tc.statusCode)
+		}
+
+		if location := response.Header.Get("Location"); location != tc.location {
+			t.Errorf("%s(%s): returned location '%s' expected '%s'", tc.method, tc.path, location, tc.location)
+		}
+	}
+}
+
+// TestHandlerAutoHead tests handling HEAD requests by GET routes
+func TestHandlerAutoHead(t *testing.T) {
+	testCases := []struct {
+		settings   *Settings
+		path       string
+		statusCode int
+		length     string
+		header     string
+	}{
+		{settings: &Settings{}, path: "/users", statusCode: StatusOK, length: "5", header: "get"},
+		{settings: &Settings{}, path: "/users/5", statusCode: StatusOK, length: "5", header: "get"},
+		{settings: &Settings{}, path: "/explicit", statusCode: StatusOK, header: "head"},
+		{settings: &Settings{}, path: "/posts", statusCode: StatusMethodNotAllowed, length: "18"},
+		{settings: &Settings{}, path: "/unknown", statusCode: StatusNotFound, length: "9"},
+		{settings: &Settings{DisableAutoHead: true}, path: "/users", statusCode: StatusMethodNotAllowed, length: "18"},
+		{settings: &Settings{DisableAutoHead: true}, path: "/explicit", statusCode: StatusOK, header: "head"},
+	}
+
+	for _, tc := range testCases {
+		tc.settings.HandleMethodNotAllowed = true
+		gb := setupGearbox(tc.settings)
+
+		getHandler := func(ctx Context) {
+			ctx.Set("X-Handler", "get")
+			ctx.SendString("users")
+		}
+		gb.Get("/users", getHandler)
+		gb.Get("/users/:id", getHandler)
+		gb.Get("/explicit", getHandler)
+		gb.Head("/explicit", func(ctx Context) {
+			ctx.Set("X-Handler", "head")
+		})
+		gb.Post("/posts", emptyHandler)
+
+		startGearbox(gb)
+
+		// Requesting twice to test cached matches
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest(MethodHead, tc.path, nil)
+			response, err := makeRequest(req, gb)
+			if err != nil {
+				t.Fatalf("%s(%s): %s", MethodHead, tc.path, err.Error())
+			}
+
+			if response.StatusCode != tc.statusCode {
+				t.Errorf("%s(%s): returned %d expected %d", MethodHead, tc.path, response.StatusCode, tc.statusCode)
+			}
+
+			if length := response.Header.Get("Content-Length"); length != tc.length {
+				t.Errorf("%s(%s): returned content length %s expected %s", MethodHead, tc.path, length, tc.length)
+			}
+
+			if header := response.Header.Get("X-Handler"); header != tc.header {
+				t.Errorf("%s(%s): handled by %s expected %s", MethodHead, tc.path, header, tc.header)
+			}
+
+			if body, _ := ioutil.ReadAll(response.Body); len(body) != 0 {
+				t.Errorf("%s(%s): returned body %s expected empty body", MethodHead, tc.path, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gearbox
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo holds information of a registered route
+type RouteInfo struct {
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	Host        string   `json:"host,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Handlers    []string `json:"handlers"`    // names of route's handlers
+	Middlewares int      `json:"middlewares"` // number of global, group and route middlewares
+}
+
+// Routes returns information of registered routes in order of registration
+func (gb *gearbox) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(gb.registeredRoutes))
+	for i, route := range gb.registeredRoutes {
+		// Handlers chain is kept once route is added to router, otherwise
+		// it's built with current middlewares
+		chain := route.chain
+		if chain == nil {
+			chain = gb.routeHandlers(route)
+		}
+
+		handlers := make([]string, len(route.Handlers))
+		for j, handler := range route.Handlers {
+			handlers[j] = handlerName(handler)
+		}
+
+		routes[i] = RouteInfo{
+			Method:      route.Method,
+			Path:        route.Path,
+			Host:        route.Host,
+			Name:        route.name,
+			Handlers:    handlers,
+			Middlewares: len(chain) - len(route.Handlers),
+		}
+	}
+	return routes
+}
+
+// RoutesHandler returns a handler that answers with table of registered
+// routes as JSON if it's requested with format=json query param or JSON
+// accept header, otherwise as plain text
+func (gb *gearbox) RoutesHandler() handlerFunc {
+	return func(ctx Context) {
+		routes := gb.Routes()
+
+		if ctx.Query("format") == "json" ||
+			strings.Contains(ctx.Get("Accept"), MIMEApplicationJSON) {
+			if err := ctx.SendJSON(routes); err != nil {
+				ctx.Status(StatusInternalServerError)
+			}
+			return
+		}
+
+		var buffer bytes.Buffer
+		w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "METHOD\tPATH\tNAME\tHANDLERS\tMIDDLEWARES")
+		for _, route := range routes {
+			fmt.Fprintf(w, "%s\t%s%s\t%s\t%s\t%d\n", route.Method, route.Host, route.Path,
+				route.Name, strings.Join(route.Handlers, ", "), route.Middlewares)
+		}
+		w.Flush()
+
+		ctx.SendBytes(buffer.Bytes())
+	}
+}
+
+// handlerName returns name of handler's function
+func handlerName(handler handlerFunc) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
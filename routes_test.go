@@ -0,0 +1,107 @@
+package gearbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// listUsers is a named handler used to test handlers' names
+func listUsers(ctx Context) {}
+
+// TestRoutes tests listing registered routes before and after starting
+func TestRoutes(t *testing.T) {
+	gb := setupGearbox()
+	gb.Use(emptyHandler)
+
+	api := gb.Group("/api")
+	api.Use(emptyHandler, emptyHandler)
+	api.Get("/users", listUsers).Name("users").Use(emptyHandler)
+	gb.Post("/login", emptyHandler, listUsers)
+	gb.Host("admin.example.com", []*Route{
+		gb.Get("/", listUsers),
+	})
+
+	expected := []RouteInfo{
+		{Method: MethodGet, Path: "/api/users", Name: "users",
+			Handlers: []string{"github.com/gogearbox/gearbox.listUsers"}, Middlewares: 4},
+		{Method: MethodPost, Path: "/login",
+			Handlers: []string{"", "github.com/gogearbox/gearbox.listUsers"}, Middlewares: 1},
+		{Method: MethodGet, Path: "/", Host: "admin.example.com",
+			Handlers: []string{"github.com/gogearbox/gearbox.listUsers"}, Middlewares: 1},
+	}
+
+	for i := 0; i < 2; i++ {
+		routes := gb.Routes()
+		if len(routes) != len(expected) {
+			t.Fatalf("returned %d routes expected %d", len(routes), len(expected))
+		}
+
+		for j, route := range routes {
+			e := expected[j]
+			if route.Method != e.Method || route.Path != e.Path || route.Host != e.Host ||
+				route.Name != e.Name || route.Middlewares != e.Middlewares ||
+				len(route.Handlers) != len(e.Handlers) {
+				t.Errorf("returned route %+v expected %+v", route, e)
+				continue
+			}
+
+			// Name of last handler is checked only since anonymous functions
+			// are named by compiler
+			last := len(e.Handlers) - 1
+			if route.Handlers[last] != e.Handlers[last] {
+				t.Errorf("returned handler %s expected %s", route.Handlers[last], e.Handlers[last])
+			}
+		}
+
+		startGearbox(gb)
+	}
+}
+
+// TestRoutesHandler tests rendering routes table as JSON and text
+func TestRoutesHandler(t *testing.T) {
+	gb := setupGearbox()
+	gb.Get("/users", listUsers).Name("users")
+	gb.Get("/debug/routes", gb.RoutesHandler())
+
+	startGearbox(gb)
+
+	// JSON
+	for _, path := range []string{"/debug/routes?format=json", "/debug/routes"} {
+		req, _ := http.NewRequest(MethodGet, path, nil)
+		if path == "/debug/routes" {
+			req.Header.Set("Accept", MIMEApplicationJSON)
+		}
+
+		response, err := makeRequest(req, gb)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err.Error())
+		}
+
+		var routes []RouteInfo
+		body, _ := ioutil.ReadAll(response.Body)
+		if err := json.Unmarshal(body, &routes); err != nil {
+			t.Fatalf("%s: returned invalid JSON %s", path, body)
+		}
+
+		if len(routes) != 2 || routes[0].Name != "users" || routes[1].Path != "/debug/routes" {
+			t.Errorf("%s: returned routes %+v", path, routes)
+		}
+	}
+
+	// Text
+	req, _ := http.NewRequest(MethodGet, "/debug/routes", nil)
+	response, err := makeRequest(req, gb)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "METHOD") ||
+		!strings.Contains(lines[1], "/users") || !strings.Contains(lines[1], "gearbox.listUsers") {
+		t.Errorf("returned table %s", body)
+	}
+}
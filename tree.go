@@ -1,11 +1,22 @@
 package gearbox
 
 import (
+	"errors"
+	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
 type nodeType uint8
 
+// CatchAllParam is the key used to store the remaining path matched by
+// unnamed catch all (*) route, e.g. ctx.Param(gearbox.CatchAllParam)
+const CatchAllParam = "*"
+
+// hitsSampleRate is the number of matches of a context between counting hits
+// of static nodes, sampling keeps atomic operations away from most requests
+const hitsSampleRate = 64
+
 const (
 	static nodeType = iota
 	root
@@ -13,18 +24,85 @@ const (
 	catchAll
 )
 
+// paramConstraints holds named constraints that can be used with parameters
+// instead of writing regular expressions, e.g. /users/:id<int>
+var paramConstraints = map[string]string{
+	"int":   `[0-9]+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"slug":  `[a-z0-9]+(?:-[a-z0-9]+)*`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// segmentPart is either a parameter or a literal text inside a route segment
+type segmentPart struct {
+	name       string
+	constraint string
+	literal    string
+}
+
+// staticChildren holds static children of a node ordered by their priority
+// and first byte of each child's path in the same order
+type staticChildren struct {
+	indices string
+	nodes   []*node
+}
+
+// node is a node of compressed radix tree, static nodes hold common prefix
+// of their children's paths which may span multiple segments, parameter
+// nodes match exactly one segment and catch all nodes match rest of path
 type node struct {
-	path     string
-	param    *node
-	children map[string]*node
-	nType    nodeType
-	handlers handlersChain
+	// priority is number of counted matches through this node, static
+	// children with higher priority are tried first
+	priority   uint32
+	reordering uint32 // set while children are reordered
+	path       string
+	keys       []string
+	groups     []int
+	pattern    string
+	regex      *regexp.Regexp
+	children   atomic.Value // *staticChildren
+	params     []*node
+	nType      nodeType
+	handlers   handlersChain
+	route      *Route
+
+	// trailingSlash is set if route was registered with trailing slash
+	trailingSlash bool
 }
 
-// addRoute adds a node with the provided handlers to the path
-func (n *node) addRoute(path string, handlers handlersChain) {
+// addRoute adds a node with the provided handlers to the path, paths with
+// optional parameters are registered once for each possible combination.
+// It returns the nodes holding handlers of the path or an error if the path
+// is invalid or conflicts with registered paths. Nodes are changed in place,
+// so routes must not be added while the tree is matching requests
+func (n *node) addRoute(path string, handlers handlersChain) ([]*node, error) {
+	if paths := expandOptionalParams(path); len(paths) > 1 {
+		nodes := make([]*node, 0, len(paths))
+		for _, p := range paths {
+			pathNodes, err := n.addRoute(p, handlers)
+			if err != nil {
+				// Paths added before are removed to not serve invalid routes
+				for _, added := range nodes {
+					added.handlers = nil
+					added.trailingSlash = false
+				}
+				return nil, err
+			}
+			nodes = append(nodes, pathNodes...)
+		}
+		return nodes, nil
+	}
+
 	currentNode := n
 	originalPath := path
+
+	// Trailing slash is not significant while matching, but it's kept to
+	// know the canonical path of route
+	trailingSlash := len(path) > 1 && path[len(path)-1] == '/'
+	if trailingSlash {
+		path = path[:len(path)-1]
+	}
 	path = path[1:]
 
 	paramNames := make(map[string]bool)
@@ -33,7 +111,8 @@ func (n *node) addRoute(path string, handlers handlersChain) {
 		pathLen := len(path)
 		if pathLen == 0 {
 			if currentNode.handlers != nil {
-				panic("handlers are already registered for path '" + originalPath + "'")
+				return nil, errors.New("handlers are already registered for path '" +
+					originalPath + "'")
 			}
 
 			// Make a deep copy of handler's references
@@ -41,138 +120,720 @@ func (n *node) addRoute(path string, handlers handlersChain) {
 			copy(routeHandlers, handlers)
 
 			currentNode.handlers = routeHandlers
-			break
+			currentNode.trailingSlash = trailingSlash
+			return []*node{currentNode}, nil
 		}
 
-		segmentDelimiter := strings.Index(path, "/")
-		if segmentDelimiter == -1 {
-			segmentDelimiter = pathLen
+		if path[0] != ':' && path[0] != '*' {
+			// Static
+			end := staticEnd(path)
+			currentNode = currentNode.addStatic(path[:end])
+			path = path[end:]
+			continue
 		}
 
+		// Parameter
+		segmentDelimiter := segmentEnd(path)
 		pathSegment := path[:segmentDelimiter]
-		if pathSegment[0] == ':' || pathSegment[0] == '*' {
-			// Parameter
-			if len(currentNode.children) > 0 {
-				panic("parameter " + pathSegment +
-					" conflicts with existing static children in path '" +
-					originalPath + "'")
+		if pathSegment[0] == '*' && pathLen > segmentDelimiter {
+			return nil, errors.New("catch all (*) routes are only allowed " +
+				"at the end of the path in path '" +
+				originalPath + "'")
+		}
+
+		child, err := newParamNode(pathSegment, originalPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keys are checked before adding the node to keep tree unchanged
+		// by invalid paths
+		for _, key := range child.keys {
+			if paramNames[key] {
+				return nil, errors.New("parameter " + pathSegment +
+					" must be unique in path '" + originalPath + "'")
 			}
+			paramNames[key] = true
+		}
 
-			if currentNode.param != nil {
-				if currentNode.param.path[0] == '*' {
-					panic("parameter " + pathSegment +
-						" conflicts with catch all (*) route in path '" +
-						originalPath + "'")
-				} else if currentNode.param.path != pathSegment {
-					panic("parameter " + pathSegment + " in new path '" +
-						originalPath + "' conflicts with existing wildcard '" +
-						currentNode.param.path)
-				}
+		if currentNode, err = currentNode.addParam(child, originalPath); err != nil {
+			return nil, err
+		}
+
+		path = path[segmentDelimiter:]
+	}
+}
+
+// addStatic adds static path to the node's children, a child that shares
+// only a part of its path with the new one is split at the end of common
+// prefix, it returns the node that ends with the whole path
+func (n *node) addStatic(path string) *node {
+	currentNode := n
+
+	for len(path) > 0 {
+		children := currentNode.staticChildren()
+
+		index := strings.IndexByte(children.indices, path[0])
+		if index == -1 {
+			child := &node{
+				path:  path,
+				nType: static,
 			}
+			child.children.Store(&staticChildren{})
 
-			if currentNode.param == nil {
-				var nType nodeType
-				if pathSegment[0] == '*' {
-					nType = catchAll
-					if pathLen > 1 {
-						panic("catch all (*) routes are only allowed " +
-							"at the end of the path in path '" +
-							originalPath + "'")
-					}
-				} else {
-					nType = param
-					if _, ok := paramNames[pathSegment]; ok {
-						panic("parameter " + pathSegment +
-							" must be unique in path '" + originalPath + "'")
-					} else {
-						paramNames[pathSegment] = true
+			currentNode.children.Store(&staticChildren{
+				indices: children.indices + path[:1],
+				nodes:   append(children.nodes[:len(children.nodes):len(children.nodes)], child),
+			})
+			return child
+		}
+
+		child := children.nodes[index]
+
+		prefixLen := 0
+		for prefixLen < len(path) && prefixLen < len(child.path) &&
+			path[prefixLen] == child.path[prefixLen] {
+			prefixLen++
+		}
+
+		if prefixLen < len(child.path) {
+			// Move child's content to a new node that holds the rest of
+			// child's path after the common prefix
+			rest := &node{
+				priority:      child.priority,
+				path:          child.path[prefixLen:],
+				params:        child.params,
+				nType:         static,
+				handlers:      child.handlers,
+				route:         child.route,
+				trailingSlash: child.trailingSlash,
+			}
+			rest.children.Store(child.staticChildren())
+
+			child.path = child.path[:prefixLen]
+			child.params = nil
+			child.handlers = nil
+			child.route = nil
+			child.trailingSlash = false
+			child.children.Store(&staticChildren{
+				indices: rest.path[:1],
+				nodes:   []*node{rest},
+			})
+		}
+
+		currentNode = child
+		path = path[prefixLen:]
+	}
+
+	return currentNode
+}
+
+// staticChildren returns static children of the node
+func (n *node) staticChildren() *staticChildren {
+	return n.children.Load().(*staticChildren)
+}
+
+// hit counts a match through the static child and moves it before siblings
+// with lower priority. Children are replaced instead of changed in place since
+// other requests may be walking through them, moving is skipped if children
+// of the node are being reordered by another request
+func (n *node) hit(child *node) {
+	priority := atomic.AddUint32(&child.priority, 1)
+	if !atomic.CompareAndSwapUint32(&n.reordering, 0, 1) {
+		return
+	}
+	defer atomic.StoreUint32(&n.reordering, 0)
+
+	children := n.staticChildren()
+	index := 0
+	for children.nodes[index] != child {
+		index++
+	}
+
+	newIndex := index
+	for newIndex > 0 && atomic.LoadUint32(&children.nodes[newIndex-1].priority) < priority {
+		newIndex--
+	}
+
+	if newIndex == index {
+		return
+	}
+
+	nodes := make([]*node, 0, len(children.nodes))
+	nodes = append(nodes, children.nodes[:newIndex]...)
+	nodes = append(nodes, child)
+	nodes = append(nodes, children.nodes[newIndex:index]...)
+	nodes = append(nodes, children.nodes[index+1:]...)
+
+	indices := make([]byte, len(nodes))
+	for i, c := range nodes {
+		indices[i] = c.path[0]
+	}
+
+	n.children.Store(&staticChildren{
+		indices: string(indices),
+		nodes:   nodes,
+	})
+}
+
+// addParam adds parameter child to the node if there is no existing one with
+// the same path and returns it, constrained parameters are kept before
+// unconstrained ones to be tried first while matching
+func (n *node) addParam(child *node, originalPath string) (*node, error) {
+	for _, p := range n.params {
+		if p.path == child.path {
+			return p, nil
+		}
+
+		if p.nType == catchAll || child.nType == catchAll {
+			return nil, errors.New("parameter " + child.path +
+				" conflicts with catch all (*) route in path '" +
+				originalPath + "'")
+		}
+
+		if p.pattern == child.pattern {
+			return nil, errors.New("parameter " + child.path + " in new path '" +
+				originalPath + "' conflicts with existing wildcard '" +
+				p.path + "'")
+		}
+	}
+
+	index := len(n.params)
+	if child.regex != nil {
+		for i, p := range n.params {
+			if p.regex == nil {
+				index = i
+				break
+			}
+		}
+	}
+
+	n.params = append(n.params, nil)
+	copy(n.params[index+1:], n.params[index:])
+	n.params[index] = child
+
+	return child, nil
+}
+
+// newParamNode parses parameter or catch all segment and creates a node for it,
+// parameter may have a constraint written between angle brackets either as
+// a regular expression or a name of a predefined one, e.g. :id<[0-9]+>, :id<int>.
+// A segment can hold several parameters separated by literal text,
+// e.g. :from-:to, :name.:ext
+func newParamNode(segment, originalPath string) (*node, error) {
+	child := &node{
+		path:  segment,
+		nType: param,
+	}
+	child.children.Store(&staticChildren{})
+
+	if segment[0] == '*' {
+		child.nType = catchAll
+		child.keys = []string{CatchAllParam}
+		if len(segment) > 1 {
+			child.keys[0] = segment[1:]
+		}
+		child.pattern = "*"
+		return child, nil
+	}
+
+	parts, err := parseSegment(segment, originalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single parameter with or without constraint
+	if len(parts) == 1 {
+		child.keys = []string{parts[0].name}
+		child.pattern = ":"
+
+		if parts[0].constraint != "" {
+			child.pattern = ":<" + parts[0].constraint + ">"
+			child.regex, err = compileConstraint("^(?:"+parts[0].constraint+")$",
+				segment, originalPath)
+		}
+		return child, err
+	}
+
+	// Multiple parameters and literals, each parameter is matched by a
+	// group in one regular expression, groups of constraints are counted
+	// to know index of each parameter's group
+	var pattern, expr strings.Builder
+	expr.WriteByte('^')
+	group := 1
+	for _, part := range parts {
+		if part.name == "" {
+			pattern.WriteString(part.literal)
+			expr.WriteString(regexp.QuoteMeta(part.literal))
+			continue
+		}
+
+		constraint := ".+"
+		if part.constraint != "" {
+			constraint = part.constraint
+		}
+
+		regex, err := compileConstraint(constraint, segment, originalPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pattern.WriteString(":<" + constraint + ">")
+		expr.WriteString("(" + constraint + ")")
+		child.keys = append(child.keys, part.name)
+		child.groups = append(child.groups, group)
+		group += 1 + regex.NumSubexp()
+	}
+	expr.WriteByte('$')
+
+	child.pattern = pattern.String()
+	if child.regex, err = compileConstraint(expr.String(), segment, originalPath); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// parseSegment splits parameter segment into parameters and literals
+func parseSegment(segment, originalPath string) ([]segmentPart, error) {
+	var parts []segmentPart
+
+	for i := 0; i < len(segment); {
+		if segment[i] != ':' {
+			start := i
+			for i < len(segment) && segment[i] != ':' {
+				i++
+			}
+			parts = append(parts, segmentPart{literal: segment[start:i]})
+			continue
+		}
+
+		i++
+		start := i
+		for i < len(segment) && isParamNameChar(segment[i]) {
+			i++
+		}
+
+		part := segmentPart{name: segment[start:i]}
+		if part.name == "" {
+			return nil, errors.New("parameter in segment " + segment +
+				" must have a name in path '" + originalPath + "'")
+		}
+
+		if i < len(segment) && segment[i] == '<' {
+			depth := 0
+			start = i + 1
+			for ; i < len(segment); i++ {
+				if segment[i] == '<' {
+					depth++
+				} else if segment[i] == '>' {
+					depth--
+					if depth == 0 {
+						break
 					}
 				}
+			}
 
-				currentNode.param = &node{
-					path:     pathSegment,
-					nType:    nType,
-					children: make(map[string]*node),
-				}
+			if i == len(segment) {
+				return nil, errors.New("constraint of parameter in segment " + segment +
+					" must end with '>' in path '" + originalPath + "'")
 			}
-			currentNode = currentNode.param
-		} else {
-			// Static
-			if currentNode.param != nil {
-				panic(pathSegment + "' conflicts with existing parameter " +
-					currentNode.param.path + " in path '" + originalPath + "'")
-			}
-			if child, ok := currentNode.children[pathSegment]; ok {
-				currentNode = child
-
-			} else {
-				child = &node{
-					path:     pathSegment,
-					nType:    static,
-					children: make(map[string]*node),
+
+			part.constraint = segment[start:i]
+			if expr, ok := paramConstraints[part.constraint]; ok {
+				part.constraint = expr
+			}
+			i++
+		}
+
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// compileConstraint compiles regular expression of parameter constraint
+func compileConstraint(expr, segment, originalPath string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.New("invalid constraint of parameter " + segment +
+			" in path '" + originalPath + "': " + err.Error())
+	}
+	return regex, nil
+}
+
+// isParamNameChar checks if character can be used in parameter name
+func isParamNameChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// expandOptionalParams returns all paths that can be built from path by
+// keeping or dropping its optional parameters, e.g. /:lang?/docs gives
+// /:lang/docs and /docs. In consecutive optional parameters, a parameter
+// is kept only if the ones before it are kept, e.g. /:year?/:month?
+// gives /:year/:month, /:year and /
+func expandOptionalParams(path string) []string {
+	paths := []string{""}
+	dropped := []bool{false}
+
+	for path = path[1:]; ; {
+		end := segmentEnd(path)
+		segment := path[:end]
+
+		optional := len(segment) > 1 && segment[0] == ':' &&
+			segment[len(segment)-1] == '?'
+		if optional {
+			segment = segment[:len(segment)-1]
+		}
+
+		count := len(paths)
+		for i := 0; i < count; i++ {
+			if !optional {
+				paths[i] += "/" + segment
+				dropped[i] = false
+				continue
+			}
+
+			if dropped[i] {
+				continue
+			}
+
+			paths = append(paths, paths[i])
+			dropped = append(dropped, true)
+			paths[i] += "/" + segment
+		}
+
+		if end == len(path) {
+			break
+		}
+		path = path[end+1:]
+	}
+
+	for i := range paths {
+		if paths[i] == "" {
+			paths[i] = "/"
+		}
+	}
+	return paths
+}
+
+// countParams returns the maximum number of parameters that can be
+// matched by the path
+func countParams(path string) (int, error) {
+	count := 0
+	originalPath := path
+	for path = path[1:]; len(path) > 0; {
+		end := segmentEnd(path)
+		segment := path[:end]
+
+		if len(segment) > 0 && segment[0] == '*' {
+			count++
+		} else if len(segment) > 0 && segment[0] == ':' {
+			parts, err := parseSegment(strings.TrimSuffix(segment, "?"), originalPath)
+			if err != nil {
+				return 0, err
+			}
+
+			for _, part := range parts {
+				if part.name != "" {
+					count++
 				}
-				currentNode.children[pathSegment] = child
-				currentNode = child
 			}
 		}
 
-		if pathLen > segmentDelimiter {
-			segmentDelimiter++
+		if end == len(path) {
+			break
+		}
+		path = path[end+1:]
+	}
+	return count, nil
+}
+
+// lowerStaticSegments returns path after converting its static segments to
+// lower case, parameter and catch all segments are kept as they are
+func lowerStaticSegments(path string) string {
+	var b strings.Builder
+	b.Grow(len(path))
+
+	for {
+		end := segmentEnd(path)
+		segment := path[:end]
+
+		if len(segment) > 0 && (segment[0] == ':' || segment[0] == '*') {
+			b.WriteString(segment)
+		} else {
+			b.WriteString(lowerASCII(segment))
+		}
+
+		if end == len(path) {
+			break
+		}
+		b.WriteByte('/')
+		path = path[end+1:]
+	}
+
+	return b.String()
+}
+
+// staticEnd returns index where the first parameter or catch all segment
+// starts in the path or its length if it's static
+func staticEnd(path string) int {
+	for i := 0; ; {
+		if i < len(path) && (path[i] == ':' || path[i] == '*') {
+			return i
+		}
+
+		end := strings.IndexByte(path[i:], '/')
+		if end == -1 {
+			return len(path)
+		}
+		i += end + 1
+	}
+}
+
+// segmentEnd returns index of the slash that ends first segment of the path,
+// slashes inside parameter constraints are skipped
+func segmentEnd(path string) int {
+	depth := 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '<':
+			depth++
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				return i
+			}
 		}
-		path = path[segmentDelimiter:]
 	}
+	return len(path)
 }
 
-// matchRoute returns handlers registered with the given path
-func (n *node) matchRoute(path string, ctx *context) handlersChain {
+// matchRoute returns the node that holds handlers registered with the given path
+func (n *node) matchRoute(path string, ctx *context) *node {
 	pathLen := len(path)
 	if pathLen > 0 && path[0] != '/' {
 		return nil
 	}
 
-	currentNode := n
+	if pathLen > 0 {
+		path = path[1:]
+	}
+
+	return n.match(path, path, ctx, ctx.countHit())
+}
+
+// matchRouteFold returns the node that holds handlers registered with the given
+// path by matching static parts case-insensitively, values of params keep their
+// original case. Static parts are expected to be registered in lower case
+func (n *node) matchRouteFold(path string, ctx *context) *node {
+	pathLen := len(path)
+	if pathLen > 0 && path[0] != '/' {
+		return nil
+	}
 
 	if pathLen > 0 {
 		path = path[1:]
 	}
 
-	for {
-		pathLen = len(path)
+	// Only ASCII letters are lowered to keep length of path, so params are
+	// taken from original path at the same offsets
+	return n.match(lowerASCII(path), path, ctx, ctx.countHit())
+}
+
+// match walks the tree from the current node, static children are tried
+// first and if they lead to a dead end, it backtracks to parameter children.
+// Params values are taken from original which has the same length as path.
+// Hits of matched static children are counted if countHits is set
+func (n *node) match(path, original string, ctx *context, countHits bool) *node {
+	pathLen := len(path)
+	if pathLen == 0 {
+		return n.withHandlers()
+	}
+
+	// Children are few in most cases, so scanning them is faster than
+	// calling strings.IndexByte
+	children := n.staticChildren()
+	for index, c := range []byte(children.indices) {
+		if c != path[0] {
+			continue
+		}
 
-		if pathLen == 0 || currentNode.nType == catchAll {
-			return currentNode.handlers
+		child := children.nodes[index]
+		childLen := len(child.path)
+
+		if pathLen >= childLen && path[:childLen] == child.path {
+			if matched := child.match(path[childLen:], original[childLen:], ctx, countHits); matched != nil {
+				if countHits {
+					n.hit(child)
+				}
+				return matched
+			}
 		}
-		segmentDelimiter := strings.Index(path, "/")
+		break
+	}
+
+	if len(n.params) > 0 {
+		segmentDelimiter := strings.IndexByte(path, '/')
 		if segmentDelimiter == -1 {
 			segmentDelimiter = pathLen
 		}
-		pathSegment := path[:segmentDelimiter]
+		pathSegment := original[:segmentDelimiter]
+		rest := path[segmentDelimiter:]
+		originalRest := original[segmentDelimiter:]
+
+		for _, child := range n.params {
+			if child.nType == catchAll {
+				if child.handlers != nil {
+					ctx.setParam(child.keys[0], original)
+					return child
+				}
+				continue
+			}
+
+			if child.groups != nil {
+				loc := child.regex.FindStringSubmatchIndex(pathSegment)
+				if loc == nil {
+					continue
+				}
+
+				if matched := child.match(rest, originalRest, ctx, countHits); matched != nil {
+					for i, key := range child.keys {
+						group := child.groups[i]
+						ctx.setParam(key, pathSegment[loc[2*group]:loc[2*group+1]])
+					}
+					return matched
+				}
+				continue
+			}
+
+			if child.regex != nil && !child.regex.MatchString(pathSegment) {
+				continue
+			}
 
-		if pathLen > segmentDelimiter {
-			segmentDelimiter++
+			if matched := child.match(rest, originalRest, ctx, countHits); matched != nil {
+				ctx.setParam(child.keys[0], pathSegment)
+				return matched
+			}
 		}
-		path = path[segmentDelimiter:]
+	}
+
+	// Trailing slash is not significant
+	if path == "/" {
+		return n.withHandlers()
+	}
+
+	return nil
+}
+
+// withHandlers returns the node if it has handlers, otherwise it returns nil
+func (n *node) withHandlers() *node {
+	if n.handlers == nil {
+		return nil
+	}
+	return n
+}
 
-		if currentNode.param != nil {
-			currentNode = currentNode.param
-			ctx.paramValues[currentNode.path[1:]] = pathSegment
+// findCaseInsensitivePath returns path of the route that matches the given
+// path ignoring case of ASCII letters, static parts are written as they were
+// registered and params are kept as they are in the given path
+func (n *node) findCaseInsensitivePath(path string) (string, bool) {
+	if len(path) == 0 || path[0] != '/' {
+		return "", false
+	}
+
+	fixedPath, ok := n.findCaseInsensitive(path[1:], make([]byte, 1, len(path)+1))
+	if !ok {
+		return "", false
+	}
+	fixedPath[0] = '/'
+
+	return string(fixedPath), true
+}
+
+// findCaseInsensitive walks the tree like match and appends matched parts
+// to buf, it returns false if there is no matching route
+func (n *node) findCaseInsensitive(path string, buf []byte) ([]byte, bool) {
+	if len(path) == 0 || path == "/" {
+		if n.handlers == nil {
+			return buf, false
+		}
+
+		if n.trailingSlash {
+			buf = append(buf, '/')
+		}
+		return buf, true
+	}
+
+	for _, child := range n.staticChildren().nodes {
+		childLen := len(child.path)
+		if len(path) >= childLen && equalFoldASCII(path[:childLen], child.path) {
+			if fixed, ok := child.findCaseInsensitive(path[childLen:], append(buf, child.path...)); ok {
+				return fixed, true
+			}
+		}
+	}
+
+	segmentDelimiter := strings.IndexByte(path, '/')
+	if segmentDelimiter == -1 {
+		segmentDelimiter = len(path)
+	}
+	pathSegment := path[:segmentDelimiter]
+
+	for _, child := range n.params {
+		if child.nType == catchAll {
+			if child.handlers != nil {
+				return append(buf, path...), true
+			}
 			continue
 		}
 
-		if child, ok := currentNode.children[pathSegment]; ok {
-			currentNode = child
+		if child.regex != nil && !child.regex.MatchString(pathSegment) {
 			continue
 		}
 
-		return nil
+		if fixed, ok := child.findCaseInsensitive(path[segmentDelimiter:], append(buf, pathSegment...)); ok {
+			return fixed, true
+		}
+	}
+
+	return buf, false
+}
+
+// equalFoldASCII reports whether s and t are equal ignoring case of ASCII letters
+func equalFoldASCII(s, t string) bool {
+	if len(s) != len(t) {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		a, b := s[i], t[i]
+		if a == b {
+			continue
+		}
+
+		if 'A' <= a && a <= 'Z' {
+			a += 'a' - 'A'
+		}
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		if a != b {
+			return false
+		}
 	}
+	return true
 }
 
 // createRootNode creates an instance of node with root type
 func createRootNode() *node {
-	return &node{
-		nType:    root,
-		path:     "/",
-		children: make(map[string]*node),
+	n := &node{
+		nType: root,
+		path:  "/",
 	}
+	n.children.Store(&staticChildren{})
+	return n
 }
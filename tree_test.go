@@ -1,15 +1,10 @@
 package gearbox
 
-import "testing"
-
-func catchPanic(f func()) (recv interface{}) {
-	defer func() {
-		recv = recover()
-	}()
-
-	f()
-	return
-}
+import (
+	"strings"
+	"sync"
+	"testing"
+)
 
 type testRoute struct {
 	path     string
@@ -21,48 +16,98 @@ func TestAddRoute(t *testing.T) {
 
 	routes := []testRoute{
 		{"/cmd/:tool/:sub", false},
-		{"/cmd/vet", true},
+		{"/cmd/vet", false},
 		{"/src/*", false},
 		{"/src/*", true},
-		{"/src/test", true},
+		{"/src/test", false},
 		{"/src/:test", true},
 		{"/src/", false},
 		{"/src1/", false},
 		{"/src1/*", false},
 		{"/search/:query", false},
-		{"/search/invalid", true},
+		{"/search/invalid", false},
 		{"/user_:name", false},
 		{"/user_x", false},
 		{"/id:id", false},
 		{"/id/:id", false},
 		{"/id/:value", true},
 		{"/id/:id/settings", false},
-		{"/id/:id/:type", true},
-		{"/*", true},
+		{"/id/:id/:type", false},
+		{"/*", false},
 		{"books/*/get", true},
 		{"/file/test", false},
 		{"/file/test", true},
-		{"/file/:test", true},
+		{"/file/:test", false},
 		{"/orders/:id/settings/:id", true},
 		{"/accounts/*/settings", true},
 		{"/results/*", false},
 		{"/results/*/view", true},
+		{"/results/:id", true},
+		{"/cmd/:name", true},
+		{"/assets/*filepath", false},
+		{"/assets/*path", true},
+		{"/assets/*filepath/view", true},
+		{"/docs/:filepath/*filepath", true},
+		{"/invoices/:id<[0-9]+>", false},
+		{"/invoices/:slug<slug>", false},
+		{"/invoices/:name", false},
+		{"/invoices/:number<[0-9]+>", true},
+		{"/invoices/:code<[0-9]+>/items", true},
+		{"/invoices/:id<[0-9]+>/items", false},
+		{"/invoices/:ref<[a-z/]+>", false},
+		{"/invoices/:bad<[0-9+>", true},
+		{"/invoices/:bad<int", true},
+		{"/invoices/:<int>", true},
+		{"/manual/:lang?/guide", false},
+		{"/manual/guide", true},
+		{"/pages/:section?/:page?", false},
+		{"/pages", true},
+		{"/range/:from-:to", false},
+		{"/range/:start-:end", true},
+		{"/range/:from-:from", true},
+		{"/range/:from.:to", false},
+		{"/download/:name.:ext", false},
+		{"/download/:file", false},
+		{"/download/:name.:", true},
+		{"/groups/:a<(?P<p7>x)>-:b", false},
+		{"/groups/:a<(x>-:b", true},
 	}
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route.path, emptyHandlersChain)
-		})
+		_, err := tree.addRoute(route.path, emptyHandlersChain)
 
 		if route.conflict {
-			if recv == nil {
-				t.Errorf("no panic for conflicting route '%s'", route.path)
+			if err == nil {
+				t.Errorf("no error for conflicting route '%s'", route.path)
 			}
-		} else if recv != nil {
-			t.Errorf("unexpected panic for route '%s': %v", route.path, recv)
+		} else if err != nil {
+			t.Errorf("unexpected error for route '%s': %v", route.path, err)
 		}
 	}
 }
 
+// TestAddRouteOptionalConflict tests that no path of a route with optional
+// params is matched if one of them conflicts
+func TestAddRouteOptionalConflict(t *testing.T) {
+	tree := createRootNode()
+	tree.addRoute("/docs", emptyHandlersChain)
+
+	if _, err := tree.addRoute("/:lang?/docs/", fakeHandlersChain); err == nil {
+		t.Fatalf("no error for conflicting route '%s'", "/:lang?/docs/")
+	}
+
+	if matched := tree.matchRoute("/en/docs", &context{}); matched != nil {
+		t.Errorf("matched path '%s' of conflicting route", "/en/docs")
+	}
+
+	if _, err := tree.addRoute("/:lang/docs", emptyHandlersChain); err != nil {
+		t.Errorf("unexpected error for route '%s': %v", "/:lang/docs", err)
+	}
+
+	if matched := tree.matchRoute("/en/docs", &context{}); matched == nil || matched.trailingSlash {
+		t.Errorf("path '%s' is not matched with route '%s'", "/en/docs", "/:lang/docs")
+	}
+}
+
 type testRequests []struct {
 	path   string
 	match  bool
@@ -87,6 +132,29 @@ func TestMatchRoute(t *testing.T) {
 		"/doc/go1.html",
 		"/α",
 		"/β",
+		"/users/me/settings",
+		"/users/:id/profile",
+		"/files/latest",
+		"/files/*",
+		"/teams/:team/members",
+		"/teams/admins/leader",
+		"/assets/*filepath",
+		"/orders/:id<int>",
+		"/orders/:uuid<uuid>",
+		"/orders/:name",
+		"/orders/:id<int>/items",
+		"/orders/latest",
+		"/codes/:code<[A-Z]{3}>",
+		"/:lang?/docs",
+		"/archive/:year<int>?/:month<int>?",
+		"/range/:from-:to",
+		"/download/:name.:ext",
+		"/download/:file",
+		"/backup/:name.tar.gz",
+		"/versions/:major<int>.:minor<int>",
+		"/versions/:tag",
+		"/groups/:a<(?P<p7>x)>-:b",
+		"/spans/:from<([0-9]+)(h|m)>-:to<([0-9]+)(h|m)>",
 	}
 	for _, route := range routes {
 		tree.addRoute(route, emptyHandlersChain)
@@ -105,13 +173,53 @@ func TestMatchRoute(t *testing.T) {
 		{"/α", true, nil},
 		{"/β", true, nil},
 		{"/users/test", true, map[string]string{"id": "test"}},
-		{"/books/title", true, nil},
+		{"/books/title", true, map[string]string{CatchAllParam: "title"}},
+		{"/books/title/chapters/1", true, map[string]string{CatchAllParam: "title/chapters/1"}},
 		{"/search/test1/settings/test2", true, map[string]string{"item1": "test1", "item2": "test2"}},
 		{"/search/test1", false, nil},
 		{"test", false, nil},
+		{"/users/me/settings", true, nil},
+		{"/users/me", true, map[string]string{"id": "me"}},
+		{"/users/me/profile", true, map[string]string{"id": "me"}},
+		{"/users/42/settings", false, nil},
+		{"/files/latest", true, nil},
+		{"/files/latest/notes.txt", true, nil},
+		{"/files/archive.zip", true, map[string]string{CatchAllParam: "archive.zip"}},
+		{"/assets/css/main.css", true, map[string]string{"filepath": "css/main.css"}},
+		{"/assets/js/", true, map[string]string{"filepath": "js/"}},
+		{"/orders/42", true, map[string]string{"id": "42"}},
+		{"/orders/6ba7b810-9dad-11d1-80b4-00c04fd430c8", true, map[string]string{"uuid": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}},
+		{"/orders/pending", true, map[string]string{"name": "pending"}},
+		{"/orders/latest", true, nil},
+		{"/orders/42/items", true, map[string]string{"id": "42"}},
+		{"/orders/pending/items", false, nil},
+		{"/codes/ABC", true, map[string]string{"code": "ABC"}},
+		{"/codes/AB", false, nil},
+		{"/codes/ABCD", false, nil},
+		{"/docs", true, nil},
+		{"/en/docs", true, map[string]string{"lang": "en"}},
+		{"/en/docs/more", false, nil},
+		{"/archive", true, nil},
+		{"/archive/2020", true, map[string]string{"year": "2020"}},
+		{"/archive/2020/05", true, map[string]string{"year": "2020", "month": "05"}},
+		{"/archive/last", false, nil},
+		{"/range/10-20", true, map[string]string{"from": "10", "to": "20"}},
+		{"/range/10", false, nil},
+		{"/download/report.pdf", true, map[string]string{"name": "report", "ext": "pdf"}},
+		{"/download/archive.tar.gz", true, map[string]string{"name": "archive.tar", "ext": "gz"}},
+		{"/download/readme", true, map[string]string{"file": "readme"}},
+		{"/backup/db.tar.gz", true, map[string]string{"name": "db"}},
+		{"/backup/db.zip", false, nil},
+		{"/versions/1.2", true, map[string]string{"major": "1", "minor": "2"}},
+		{"/versions/1.x", true, map[string]string{"tag": "1.x"}},
+		{"/groups/x-y", true, map[string]string{"a": "x", "b": "y"}},
+		{"/spans/1h-30m", true, map[string]string{"from": "1h", "to": "30m"}},
+		{"/spans/1h-30s", false, nil},
+		{"/teams/admins/leader", true, nil},
+		{"/teams/admins/members", true, map[string]string{"team": "admins"}},
 	}
 	for _, request := range requests {
-		ctx := &context{paramValues: make(map[string]string)}
+		ctx := &context{}
 		handler := tree.matchRoute(request.path, ctx)
 
 		if handler == nil {
@@ -131,3 +239,446 @@ func TestMatchRoute(t *testing.T) {
 		}
 	}
 }
+
+// benchmarkRoutes is a set of routes similar to a real API
+var benchmarkRoutes = [...]string{
+	"/",
+	"/authorizations",
+	"/authorizations/:id",
+	"/applications/:client_id/tokens/:access_token",
+	"/events",
+	"/repos/:owner/:repo/events",
+	"/networks/:owner/:repo/events",
+	"/orgs/:org/events",
+	"/users/:user/received_events",
+	"/users/:user/received_events/public",
+	"/users/:user/events",
+	"/users/:user/events/public",
+	"/users/:user/events/orgs/:org",
+	"/feeds",
+	"/notifications",
+	"/repos/:owner/:repo/notifications",
+	"/notifications/threads/:id",
+	"/notifications/threads/:id/subscription",
+	"/repos/:owner/:repo/stargazers",
+	"/users/:user/starred",
+	"/user/starred",
+	"/user/starred/:owner/:repo",
+	"/repos/:owner/:repo/subscribers",
+	"/users/:user/subscriptions",
+	"/user/subscriptions",
+	"/user/subscriptions/:owner/:repo",
+	"/users/:user/gists",
+	"/gists",
+	"/gists/public",
+	"/gists/starred",
+	"/gists/:id",
+	"/gists/:id/star",
+	"/repos/:owner/:repo/git/blobs/:sha",
+	"/repos/:owner/:repo/git/commits/:sha",
+	"/repos/:owner/:repo/git/refs",
+	"/repos/:owner/:repo/git/tags/:sha",
+	"/repos/:owner/:repo/git/trees/:sha",
+	"/api/v1/organizations/settings/billing/invoices/history",
+	"/api/v1/organizations/settings/billing/payment/methods",
+	"/api/v1/organizations/settings/security/audit/logs",
+	"/static/*filepath",
+}
+
+// segmentNode is the tree used before the radix tree, it stores one map
+// entry per path segment and is kept to compare benchmarks of both trees
+type segmentNode struct {
+	param    *node
+	params   []*segmentNode
+	children map[string]*segmentNode
+	handlers handlersChain
+}
+
+// addRoute adds a node with the provided handlers to the path, paths are
+// expected to be valid with one parameter per segment and no optional ones
+func (n *segmentNode) addRoute(path string, handlers handlersChain) {
+	currentNode := n
+	for path = path[1:]; len(path) > 0; {
+		end := segmentEnd(path)
+		segment := path[:end]
+
+		if segment[0] == ':' || segment[0] == '*' {
+			var child *segmentNode
+			for _, p := range currentNode.params {
+				if p.param.path == segment {
+					child = p
+				}
+			}
+
+			if child == nil {
+				param, _ := newParamNode(segment, path)
+				child = &segmentNode{param: param, children: make(map[string]*segmentNode)}
+				currentNode.params = append(currentNode.params, child)
+			}
+			currentNode = child
+		} else {
+			child, ok := currentNode.children[segment]
+			if !ok {
+				child = &segmentNode{children: make(map[string]*segmentNode)}
+				currentNode.children[segment] = child
+			}
+			currentNode = child
+		}
+
+		if end < len(path) {
+			end++
+		}
+		path = path[end:]
+	}
+	currentNode.handlers = handlers
+}
+
+// matchRoute returns handlers registered with the given path
+func (n *segmentNode) matchRoute(path string, ctx *context) handlersChain {
+	if len(path) > 0 && path[0] != '/' {
+		return nil
+	}
+
+	if len(path) > 0 {
+		path = path[1:]
+	}
+	return n.match(path, ctx)
+}
+
+// match walks the tree segment by segment, static children are tried
+// first and if they lead to a dead end, it backtracks to parameter children
+func (n *segmentNode) match(path string, ctx *context) handlersChain {
+	pathLen := len(path)
+	if pathLen == 0 {
+		return n.handlers
+	}
+
+	segmentDelimiter := strings.IndexByte(path, '/')
+	if segmentDelimiter == -1 {
+		segmentDelimiter = pathLen
+	}
+	pathSegment := path[:segmentDelimiter]
+	remaining := path
+
+	if pathLen > segmentDelimiter {
+		segmentDelimiter++
+	}
+	path = path[segmentDelimiter:]
+
+	if child, ok := n.children[pathSegment]; ok {
+		if handlers := child.match(path, ctx); handlers != nil {
+			return handlers
+		}
+	}
+
+	for _, child := range n.params {
+		if child.param.nType == catchAll {
+			if child.handlers != nil {
+				ctx.setParam(child.param.keys[0], remaining)
+			}
+			return child.handlers
+		}
+
+		if child.param.regex != nil && !child.param.regex.MatchString(pathSegment) {
+			continue
+		}
+
+		if handlers := child.match(path, ctx); handlers != nil {
+			ctx.setParam(child.param.keys[0], pathSegment)
+			return handlers
+		}
+	}
+
+	return nil
+}
+
+// benchmarkTree returns a tree with benchmark routes
+func benchmarkTree() *node {
+	tree := createRootNode()
+	for _, route := range benchmarkRoutes {
+		tree.addRoute(route, fakeHandlersChain)
+	}
+	return tree
+}
+
+// benchmarkTrees returns match functions of the radix tree and the segment
+// tree holding benchmark routes to compare them
+func benchmarkTrees() []struct {
+	name  string
+	match func(path string, ctx *context) bool
+} {
+	tree := benchmarkTree()
+
+	segmentTree := &segmentNode{children: make(map[string]*segmentNode)}
+	for _, route := range benchmarkRoutes {
+		segmentTree.addRoute(route, fakeHandlersChain)
+	}
+
+	return []struct {
+		name  string
+		match func(path string, ctx *context) bool
+	}{
+		{
+			name: "radix",
+			match: func(path string, ctx *context) bool {
+				return tree.matchRoute(path, ctx) != nil
+			},
+		},
+		{
+			name: "segment",
+			match: func(path string, ctx *context) bool {
+				return segmentTree.matchRoute(path, ctx) != nil
+			},
+		},
+	}
+}
+
+// benchmarkPaths returns paths that match each of benchmark routes
+func benchmarkPaths() []string {
+	paths := make([]string, len(benchmarkRoutes))
+	for i, route := range benchmarkRoutes {
+		paths[i] = strings.NewReplacer(":", "", "*", "").Replace(route)
+	}
+	return paths
+}
+
+// benchmarkMatch benchmarks matching path in trees with benchmark routes
+func benchmarkMatch(b *testing.B, path string) {
+	for _, tree := range benchmarkTrees() {
+		match := tree.match
+		b.Run(tree.name, func(b *testing.B) {
+			ctx := &context{params: make([]pathParam, 0, 8)}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ctx.params = ctx.params[:0]
+				if !match(path, ctx) {
+					b.Fatalf("no match for path '%s'", path)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchRouteStatic(b *testing.B) {
+	benchmarkMatch(b, "/user/subscriptions")
+}
+
+func BenchmarkMatchRouteLongStatic(b *testing.B) {
+	benchmarkMatch(b, "/api/v1/organizations/settings/billing/payment/methods")
+}
+
+func BenchmarkMatchRouteParams(b *testing.B) {
+	benchmarkMatch(b, "/repos/gogearbox/gearbox/git/commits/4ad49d5")
+}
+
+func BenchmarkMatchRouteCatchAll(b *testing.B) {
+	benchmarkMatch(b, "/static/css/themes/dark.css")
+}
+
+func BenchmarkMatchRouteAll(b *testing.B) {
+	paths := benchmarkPaths()
+
+	for _, tree := range benchmarkTrees() {
+		match := tree.match
+		b.Run(tree.name, func(b *testing.B) {
+			ctx := &context{params: make([]pathParam, 0, 8)}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, path := range paths {
+					ctx.params = ctx.params[:0]
+					if !match(path, ctx) {
+						b.Fatalf("no match for path '%s'", path)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchRouteAllParallel(b *testing.B) {
+	paths := benchmarkPaths()
+
+	for _, tree := range benchmarkTrees() {
+		match := tree.match
+		b.Run(tree.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := &context{params: make([]pathParam, 0, 8)}
+				for pb.Next() {
+					for _, path := range paths {
+						ctx.params = ctx.params[:0]
+						match(path, ctx)
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestMatchRoutePriority tests that static children with more hits are
+// moved before their siblings while requests are matched concurrently
+func TestMatchRoutePriority(t *testing.T) {
+	tree := createRootNode()
+
+	routes := [...]string{"/alpha", "/beta", "/gamma", "/gamma/x", "/delta"}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandlersChain)
+	}
+
+	hits := []struct {
+		path  string
+		count int
+	}{
+		{path: "/delta", count: 4000},
+		{path: "/gamma/x", count: 2000},
+		{path: "/beta", count: 1000},
+	}
+
+	var wg sync.WaitGroup
+	for _, hit := range hits {
+		wg.Add(1)
+		go func(path string, count int) {
+			defer wg.Done()
+			ctx := &context{}
+			for i := 0; i < count*hitsSampleRate; i++ {
+				if tree.matchRoute(path, ctx) == nil {
+					t.Errorf("no match for path '%s'", path)
+					return
+				}
+			}
+		}(hit.path, hit.count)
+	}
+	wg.Wait()
+
+	// A reorder is skipped while another request is reordering, so one more
+	// hit of each path in order of their hits moves children to their places
+	for _, hit := range hits {
+		tree.matchRoute(hit.path, &context{matches: hitsSampleRate - 1})
+	}
+
+	children := tree.staticChildren()
+	if children.indices != "dgba" {
+		t.Errorf("children are ordered as '%s' expected 'dgba'", children.indices)
+	}
+
+	for i, child := range children.nodes {
+		if child.path[0] != children.indices[i] {
+			t.Errorf("index '%c' does not match child '%s'", children.indices[i], child.path)
+		}
+	}
+
+	for _, route := range routes {
+		if tree.matchRoute(route, &context{}) == nil {
+			t.Errorf("no match for path '%s'", route)
+		}
+	}
+}
+
+// TestMatchRouteFold tests matching static parts case-insensitively while
+// keeping the original case of params
+func TestMatchRouteFold(t *testing.T) {
+	tree := createRootNode()
+
+	routes := [...]string{
+		"/users/:id",
+		"/users/:id/Settings",
+		"/codes/:code<[A-Z]+>",
+		"/files/*filepath",
+		"/Static/Page",
+	}
+	for _, route := range routes {
+		tree.addRoute(lowerStaticSegments(route), fakeHandlersChain)
+	}
+
+	requests := testRequests{
+		{"/users/aBc62Z", true, map[string]string{"id": "aBc62Z"}},
+		{"/USERS/aBc62Z", true, map[string]string{"id": "aBc62Z"}},
+		{"/Users/İAbc", true, map[string]string{"id": "İAbc"}},
+		{"/USERS/ΑΒΓ/Settings", true, map[string]string{"id": "ΑΒΓ"}},
+		{"/Users/XyZ/SETTINGS", true, map[string]string{"id": "XyZ"}},
+		{"/codes/ABC", true, map[string]string{"code": "ABC"}},
+		{"/codes/abc", false, nil},
+		{"/Files/Docs/ReadMe.MD", true, map[string]string{"filepath": "Docs/ReadMe.MD"}},
+		{"/static/page", true, nil},
+		{"/STATIC/PAGE", true, nil},
+		{"/static/pages", false, nil},
+	}
+	for _, request := range requests {
+		ctx := &context{}
+		handler := tree.matchRouteFold(request.path, ctx)
+
+		if (handler != nil) != request.match {
+			t.Errorf("handle mismatch for route '%s': expected match %t", request.path, request.match)
+		}
+
+		for expectedKey, expectedValue := range request.params {
+			actualValue := ctx.Param(expectedKey)
+			if actualValue != expectedValue {
+				t.Errorf(" mismatch for route '%s' parameter '%s' actual '%s', expected '%s'",
+					request.path, expectedKey, actualValue, expectedValue)
+			}
+		}
+	}
+}
+
+// TestLowerStaticSegments tests converting static segments to lower case
+func TestLowerStaticSegments(t *testing.T) {
+	paths := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/Users/:userID/Posts", "/users/:userID/posts"},
+		{"/Codes/:code<[A-Z/]+>/View", "/codes/:code<[A-Z/]+>/view"},
+		{"/Files/*FilePath", "/files/*FilePath"},
+		{"/ΑΒΓ/", "/ΑΒΓ/"},
+		{"/İstanbul/:id", "/İstanbul/:id"},
+	}
+
+	for _, p := range paths {
+		if actual := lowerStaticSegments(p.path); actual != p.expected {
+			t.Errorf("path '%s': returned '%s' expected '%s'", p.path, actual, p.expected)
+		}
+	}
+}
+
+// TestFindCaseInsensitivePath tests finding path of routes ignoring case
+func TestFindCaseInsensitivePath(t *testing.T) {
+	tree := createRootNode()
+
+	routes := [...]string{
+		"/Users/:id",
+		"/users/:id/Settings/",
+		"/Codes/:code<[a-z]+>",
+		"/Files/*filepath",
+		"/About",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandlersChain)
+	}
+
+	requests := []struct {
+		path     string
+		found    bool
+		expected string
+	}{
+		{"/users/AbC", true, "/Users/AbC"},
+		{"/USERS/AbC/settings", true, "/users/AbC/Settings/"},
+		{"/codes/abc", true, "/Codes/abc"},
+		{"/codes/ABC", false, ""},
+		{"/files/Docs/ReadMe.md", true, "/Files/Docs/ReadMe.md"},
+		{"/about/", true, "/About"},
+		{"/abouts", false, ""},
+		{"about", false, ""},
+	}
+
+	for _, request := range requests {
+		fixedPath, found := tree.findCaseInsensitivePath(request.path)
+		if found != request.found || fixedPath != request.expected {
+			t.Errorf("path '%s': returned '%s' (%t) expected '%s' (%t)",
+				request.path, fixedPath, found, request.expected, request.found)
+		}
+	}
+}
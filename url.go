@@ -0,0 +1,143 @@
+package gearbox
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URL builds path of the route registered with the name by replacing its
+// params with values given as key and value pairs, values are escaped and
+// they must satisfy constraints of params, e.g. URL("user.show", "id", "42")
+func (gb *gearbox) URL(name string, pairs ...string) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("params of route '%s' must be key and value pairs", name)
+	}
+
+	for _, route := range gb.registeredRoutes {
+		if route.name != name {
+			continue
+		}
+
+		params := make(map[string]string, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			params[pairs[i]] = pairs[i+1]
+		}
+
+		return buildPath(route.Path, params)
+	}
+
+	return "", fmt.Errorf("route '%s' is not registered", name)
+}
+
+// buildPath replaces params of route's path with their values, optional
+// params without values are dropped with their segments
+func buildPath(path string, params map[string]string) (string, error) {
+	var b strings.Builder
+	used := make(map[string]bool, len(params))
+	dropped := false
+	originalPath := path
+
+	for path = path[1:]; ; {
+		end := segmentEnd(path)
+		segment := path[:end]
+
+		switch {
+		case len(segment) > 0 && segment[0] == '*':
+			key := CatchAllParam
+			if len(segment) > 1 {
+				key = segment[1:]
+			}
+
+			value, ok := params[key]
+			if !ok {
+				return "", fmt.Errorf("missing value of parameter %s of path '%s'",
+					key, originalPath)
+			}
+			used[key] = true
+
+			// Catch all routes do not match an empty rest of path
+			value = strings.TrimPrefix(value, "/")
+			if value == "" {
+				return "", fmt.Errorf("empty value of parameter %s of path '%s'",
+					key, originalPath)
+			}
+
+			// Slashes separate segments of catch all value
+			parts := strings.Split(value, "/")
+			for i := range parts {
+				parts[i] = url.PathEscape(parts[i])
+			}
+			b.WriteString("/" + strings.Join(parts, "/"))
+
+		case len(segment) > 0 && segment[0] == ':':
+			optional := segment[len(segment)-1] == '?'
+			if optional {
+				segment = segment[:len(segment)-1]
+			}
+
+			parts, err := parseSegment(segment, originalPath)
+			if err != nil {
+				return "", err
+			}
+			if optional {
+				if _, ok := params[parts[0].name]; !ok {
+					dropped = true
+					break
+				} else if dropped {
+					return "", fmt.Errorf("optional parameter %s of path '%s' can not "+
+						"have a value without values of optional parameters before it",
+						parts[0].name, originalPath)
+				}
+			} else {
+				dropped = false
+			}
+
+			b.WriteByte('/')
+			for _, part := range parts {
+				if part.name == "" {
+					b.WriteString(part.literal)
+					continue
+				}
+
+				value, ok := params[part.name]
+				if !ok {
+					return "", fmt.Errorf("missing value of parameter %s of path '%s'",
+						part.name, originalPath)
+				}
+				used[part.name] = true
+
+				if part.constraint != "" {
+					regex, err := regexp.Compile("^(?:" + part.constraint + ")$")
+					if err != nil || !regex.MatchString(value) {
+						return "", fmt.Errorf("value '%s' of parameter %s does not "+
+							"satisfy its constraint in path '%s'", value, part.name, originalPath)
+					}
+				}
+
+				b.WriteString(url.PathEscape(value))
+			}
+
+		default:
+			dropped = false
+			b.WriteString("/" + segment)
+		}
+
+		if end == len(path) {
+			break
+		}
+		path = path[end+1:]
+	}
+
+	for key := range params {
+		if !used[key] {
+			return "", fmt.Errorf("parameter %s is not used in path '%s'", key, originalPath)
+		}
+	}
+
+	if b.Len() == 0 {
+		return "/", nil
+	}
+	return b.String(), nil
+}
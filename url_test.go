@@ -0,0 +1,97 @@
+package gearbox
+
+import (
+	"testing"
+)
+
+// TestURL tests building paths of named routes
+func TestURL(t *testing.T) {
+	gb := setupGearbox()
+
+	gb.Get("/", emptyHandler).Name("home")
+	gb.Get("/users/:id", emptyHandler).Name("user.show")
+	gb.Get("/orders/:id<int>", emptyHandler).Name("order.show")
+	gb.Get("/docs/", emptyHandler).Name("docs")
+	gb.Get("/files/*filepath", emptyHandler).Name("files")
+	gb.Get("/assets/*", emptyHandler).Name("assets")
+	gb.Get("/:lang?/about", emptyHandler).Name("about")
+	gb.Get("/archive/:year?/:month?", emptyHandler).Name("archive")
+	gb.Get("/flights/:from-:to", emptyHandler).Name("flight")
+	gb.Get("/user_:name/profile", emptyHandler).Name("profile")
+
+	testCases := []struct {
+		name  string
+		pairs []string
+		url   string
+		isErr bool
+	}{
+		{name: "home", url: "/"},
+		{name: "user.show", pairs: []string{"id", "42"}, url: "/users/42"},
+		{name: "user.show", pairs: []string{"id", "john doe/x?"}, url: "/users/john%20doe%2Fx%3F"},
+		{name: "user.show", isErr: true},
+		{name: "user.show", pairs: []string{"id"}, isErr: true},
+		{name: "user.show", pairs: []string{"id", "42", "page", "2"}, isErr: true},
+		{name: "order.show", pairs: []string{"id", "42"}, url: "/orders/42"},
+		{name: "order.show", pairs: []string{"id", "abc"}, isErr: true},
+		{name: "docs", url: "/docs/"},
+		{name: "files", pairs: []string{"filepath", "css/main file.css"}, url: "/files/css/main%20file.css"},
+		{name: "files", pairs: []string{"filepath", ""}, isErr: true},
+		{name: "files", pairs: []string{"filepath", "/"}, isErr: true},
+		{name: "files", isErr: true},
+		{name: "assets", isErr: true},
+		{name: "assets", pairs: []string{CatchAllParam, "img/logo.png"}, url: "/assets/img/logo.png"},
+		{name: "about", url: "/about"},
+		{name: "about", pairs: []string{"lang", "en"}, url: "/en/about"},
+		{name: "archive", url: "/archive"},
+		{name: "archive", pairs: []string{"year", "2020"}, url: "/archive/2020"},
+		{name: "archive", pairs: []string{"year", "2020", "month", "05"}, url: "/archive/2020/05"},
+		{name: "archive", pairs: []string{"month", "05"}, isErr: true},
+		{name: "flight", pairs: []string{"from", "CAI", "to", "BER"}, url: "/flights/CAI-BER"},
+		{name: "flight", pairs: []string{"from", "CAI"}, isErr: true},
+		{name: "profile", url: "/user_:name/profile"},
+		{name: "unknown", isErr: true},
+	}
+
+	// Building URLs works before and after starting
+	for i := 0; i < 2; i++ {
+		for _, tc := range testCases {
+			url, err := gb.URL(tc.name, tc.pairs...)
+			if (err != nil) != tc.isErr {
+				t.Errorf("URL(%s, %v): returned error %v expected error %t", tc.name, tc.pairs, err, tc.isErr)
+			}
+
+			if url != tc.url {
+				t.Errorf("URL(%s, %v): returned %s expected %s", tc.name, tc.pairs, url, tc.url)
+			}
+		}
+
+		startGearbox(gb)
+	}
+}
+
+// TestURLDuplicateName tests registering routes with the same name
+func TestURLDuplicateName(t *testing.T) {
+	gb := setupGearbox()
+	gb.Get("/users", emptyHandler).Name("users")
+	gb.Post("/users", emptyHandler).Name("users")
+	for _, route := range gb.Any("/ping", emptyHandler) {
+		route.Name("ping")
+	}
+
+	if err := gb.Build(); err != nil {
+		t.Fatalf("routes of the same path: unexpected error %v", err)
+	}
+
+	if url, err := gb.URL("ping"); err != nil || url != "/ping" {
+		t.Errorf("returned %s, %v expected %s", url, err, "/ping")
+	}
+
+	gb.Get("/accounts", emptyHandler).Name("users")
+	gb.Get("/pong", emptyHandler).Name("ping")
+	gb.Host("api.example.com", []*Route{gb.Get("/users", emptyHandler).Name("users")})
+
+	err := gb.Build()
+	if routeErrors, ok := err.(RouteErrors); !ok || len(routeErrors) != 3 {
+		t.Errorf("duplicate route names: returned %v expected %d errors", err, 3)
+	}
+}
@@ -1,6 +1,7 @@
 package gearbox
 
 import (
+	"path"
 	"unsafe"
 )
 
@@ -9,3 +10,47 @@ import (
 func GetString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
+
+// cleanPath returns the shortest path equivalent to p by removing duplicate
+// slashes and resolving . and .. elements, trailing slash is kept
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean("/" + p)
+	if p[len(p)-1] == '/' && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// lowerASCII returns s with ASCII upper case letters mapped to lower case,
+// other bytes are kept so the length does not change
+func lowerASCII(s string) string {
+	i := 0
+	for i < len(s) && (s[i] < 'A' || s[i] > 'Z') {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// containsString checks if s is one of values
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
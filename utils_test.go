@@ -1,6 +1,9 @@
 package gearbox
 
-import "fmt"
+import (
+	"fmt"
+	"testing"
+)
 
 // ExampleGetString tests converting []byte to string
 func ExampleGetString() {
@@ -29,3 +32,47 @@ func ExampleGetString() {
 	// true
 	// 0
 }
+
+// TestCleanPath tests cleaning paths from duplicate slashes, . and .. elements
+func TestCleanPath(t *testing.T) {
+	paths := []struct {
+		path     string
+		expected string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"//", "/"},
+		{"/a//b/../c", "/a/c"},
+		{"/a/./b/", "/a/b/"},
+		{"/../a", "/a"},
+		{"a/b", "/a/b"},
+		{"/a/b/..", "/a"},
+		{"/a/b/../", "/a/"},
+	}
+
+	for _, p := range paths {
+		if actual := cleanPath(p.path); actual != p.expected {
+			t.Errorf("path '%s': returned '%s' expected '%s'", p.path, actual, p.expected)
+		}
+	}
+}
+
+// TestLowerASCII tests lowering ASCII letters without changing length
+func TestLowerASCII(t *testing.T) {
+	values := []struct {
+		value    string
+		expected string
+	}{
+		{"", ""},
+		{"/users", "/users"},
+		{"/Users/ABC", "/users/abc"},
+		{"/İAbc", "/İabc"},
+		{"ΑΒΓ-X", "ΑΒΓ-x"},
+	}
+
+	for _, v := range values {
+		if actual := lowerASCII(v.value); actual != v.expected {
+			t.Errorf("value '%s': returned '%s' expected '%s'", v.value, actual, v.expected)
+		}
+	}
+}